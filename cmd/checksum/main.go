@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gosuri/uiprogress"
+	"github.com/je4/utils/v2/pkg/checksum"
+	"github.com/je4/utils/v2/pkg/stream"
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+)
+
+var basedir = flag.String("basedir", ".", "base directory, all paths are relative to it. zip files are treated as folders")
+var digests = flag.String("digest", "sha512", "comma separated list of digest algorithms")
+var workers = flag.Int("workers", 4, "number of parallel workers")
+var format = flag.String("format", "text", "output format: text, json or csv")
+var check = flag.String("check", "", "manifest file to verify against")
+var manifestFormat = flag.String("manifestformat", "gnu", "format of the -check manifest: gnu, bsd or bagit")
+var showProgress = flag.Bool("progress", false, "show progress bar per file")
+
+// progressFS shows a progress bar for every file opened for reading
+type progressFS struct {
+	zipasfolder.FSRW
+}
+
+func (pfs *progressFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(pfs.FSRW, name)
+}
+
+func (pfs *progressFS) Open(name string) (fs.File, error) {
+	fp, err := pfs.FSRW.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fp.Stat()
+	if err != nil || fi.IsDir() {
+		return fp, nil
+	}
+	bar := uiprogress.AddBar(100).AppendCompleted()
+	bar.PrependFunc(func(b *uiprogress.Bar) string {
+		return name
+	})
+	pr := stream.NewProgressReaderWriter(
+		fi.Size(),
+		time.Second,
+		func(remaining time.Duration, percent float64, estimated time.Time, complete bool) {
+			if complete {
+				percent = 100
+			}
+			bar.Set(int(percent))
+		})
+	return &progressFile{File: fp, reader: pr.StartReader(fp)}, nil
+}
+
+type progressFile struct {
+	fs.File
+	reader io.Reader
+}
+
+func (pf *progressFile) Read(p []byte) (int, error) {
+	return pf.reader.Read(p)
+}
+
+func parseDigests(list string) ([]checksum.DigestAlgorithm, error) {
+	var result = []checksum.DigestAlgorithm{}
+	for _, name := range strings.Split(list, ",") {
+		var alg checksum.DigestAlgorithm
+		if err := alg.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+			return nil, err
+		}
+		result = append(result, alg)
+	}
+	return result, nil
+}
+
+func writeResult(w io.Writer, m checksum.Manifest, algs []checksum.DigestAlgorithm) error {
+	switch *format {
+	case "text":
+		return m.Write(w, checksum.ManifestFormatBSD, algs...)
+	case "json":
+		type entry struct {
+			Path    string                              `json:"path"`
+			Digests map[checksum.DigestAlgorithm]string `json:"digests"`
+		}
+		var entries = []entry{}
+		for _, p := range m.Paths() {
+			entries = append(entries, entry{Path: p, Digests: m[p]})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := []string{"path"}
+		for _, alg := range algs {
+			header = append(header, string(alg))
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, p := range m.Paths() {
+			record := []string{p}
+			for _, alg := range algs {
+				record = append(record, m[p][alg])
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+}
+
+func writeReport(w io.Writer, report *checksum.ManifestReport) error {
+	switch *format {
+	case "text":
+		for _, p := range report.OK {
+			fmt.Fprintf(w, "%s: OK\n", p)
+		}
+		for _, mm := range report.Mismatch {
+			fmt.Fprintf(w, "%s: FAILED (%s)\n", mm.Path, mm.Algorithm)
+		}
+		for _, p := range report.Missing {
+			fmt.Fprintf(w, "%s: MISSING\n", p)
+		}
+		for _, p := range report.Extra {
+			fmt.Fprintf(w, "%s: EXTRA\n", p)
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "status", "algorithm", "expected", "actual"})
+		for _, p := range report.OK {
+			cw.Write([]string{p, "ok", "", "", ""})
+		}
+		for _, mm := range report.Mismatch {
+			cw.Write([]string{mm.Path, "mismatch", string(mm.Algorithm), mm.Expected, mm.Actual})
+		}
+		for _, p := range report.Missing {
+			cw.Write([]string{p, "missing", "", "", ""})
+		}
+		for _, p := range report.Extra {
+			cw.Write([]string{p, "extra", "", "", ""})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+}
+
+// errInvalidManifest signals a failed check, the report has been written already
+var errInvalidManifest = errors.New("manifest check failed")
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		if !errors.Is(err, errInvalidManifest) {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run does the work of main, so that deferred functions run before os.Exit
+func run() (err error) {
+	algs, err := parseDigests(*digests)
+	if err != nil {
+		return fmt.Errorf("invalid digest: %v\navailable digests: %v", err, checksum.RegisteredDigests())
+	}
+
+	zipFS := zipasfolder.NewFS(zipasfolder.NewDummyOSRW(*basedir), 20)
+	defer func() {
+		// closing flushes staged writes
+		if closeErr := zipFS.(zipasfolder.FSRWClose).Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("cannot close filesystem: %w", closeErr)
+		}
+	}()
+	var fsys fs.FS = zipFS
+	if *showProgress {
+		fsys = &progressFS{FSRW: zipFS}
+		uiprogress.Start()
+	}
+
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	for i, root := range roots {
+		roots[i] = path.Clean(strings.TrimPrefix(strings.ReplaceAll(root, "\\", "/"), "/"))
+	}
+
+	if *check != "" {
+		var mf checksum.ManifestFormat
+		if err := mf.UnmarshalText([]byte(*manifestFormat)); err != nil {
+			return err
+		}
+		fp, err := os.Open(*check)
+		if err != nil {
+			return fmt.Errorf("cannot open manifest '%s': %v", *check, err)
+		}
+		m, err := checksum.ReadManifest(fp, mf, algs[0])
+		fp.Close()
+		if err != nil {
+			return fmt.Errorf("cannot read manifest '%s': %v", *check, err)
+		}
+		if len(roots) > 1 {
+			return errors.New("only one root allowed in check mode")
+		}
+		report, err := checksum.VerifyManifest(fsys, roots[0], m, *workers)
+		if err != nil {
+			return fmt.Errorf("cannot verify '%s': %v", roots[0], err)
+		}
+		if *showProgress {
+			uiprogress.Stop()
+		}
+		if err := writeReport(os.Stdout, report); err != nil {
+			return fmt.Errorf("cannot write report: %v", err)
+		}
+		if !report.Valid() {
+			return errInvalidManifest
+		}
+		return nil
+	}
+
+	var m = checksum.Manifest{}
+	for _, root := range roots {
+		rm, err := checksum.CreateManifest(fsys, root, algs, *workers)
+		if err != nil {
+			return fmt.Errorf("cannot create checksums of '%s': %v", root, err)
+		}
+		m.Add(rm)
+	}
+	if *showProgress {
+		uiprogress.Stop()
+	}
+	if err := writeResult(os.Stdout, m, algs); err != nil {
+		return fmt.Errorf("cannot write result: %v", err)
+	}
+	return nil
+}
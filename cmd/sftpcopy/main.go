@@ -19,6 +19,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -35,6 +36,10 @@ func main() {
 	flag.StringVar(&privateKey, "identity", "", "private key file")
 	concurrency := flag.Int("concurrency", 50, "sftp client concurrency")
 	maxPacketSize := flag.Int("maxpacketsize", 512*1024, "max packet size for sftp upload")
+	syncMode := flag.String("sync", "", "skip unchanged files of directories [size|checksum]")
+	include := flag.String("include", "", "comma separated glob patterns of files to upload from directories")
+	exclude := flag.String("exclude", "", "comma separated glob patterns of files and directories to skip")
+	dryRun := flag.Bool("dryrun", false, "only report the actions for directories")
 	flag.Parse()
 	tail := flag.Args()
 	if len(tail) < 2 {
@@ -58,10 +63,6 @@ func main() {
 		fmt.Printf("cannot stat %s: %v\n", src, err)
 		os.Exit(1)
 	}
-	if fi.IsDir() {
-		fmt.Printf("%s is a directory\n", src)
-		os.Exit(1)
-	}
 
 	target := tail[1]
 	matches := targetRegex.FindStringSubmatch(target)
@@ -77,6 +78,46 @@ func main() {
 	logger, lf := lm.CreateLogger("sftp", "", nil, loglevel, logFormat)
 	defer lf.Close()
 
+	rawurl := fmt.Sprintf("sftp://%s@%s:%s/%s", targetUser, targetHost, targetPort, targetPath)
+	targetUrl, err := url.Parse(rawurl)
+	if err != nil {
+		fmt.Printf("cannot parse url %s: %v\n", rawurl, err)
+		os.Exit(1)
+	}
+
+	if fi.IsDir() {
+		opts := &ssh.DirOptions{
+			Include: splitPatterns(*include),
+			Exclude: splitPatterns(*exclude),
+			DryRun:  *dryRun,
+		}
+		switch *syncMode {
+		case "":
+		case "size":
+			opts.Sync = ssh.SyncSizeModTime
+		case "checksum":
+			opts.Sync = ssh.SyncChecksum
+		default:
+			fmt.Printf("invalid sync mode %s\n", *syncMode)
+			os.Exit(1)
+		}
+		sftp, err := ssh.NewSFTP([]string{privateKey}, "", "", *concurrency, maxClientConcurrency, *maxPacketSize, nil, logger)
+		if err != nil {
+			fmt.Printf("cannot initialize sftp: %v\n", err)
+			os.Exit(1)
+		}
+		report, err := sftp.PutDir(targetUrl, os.DirFS(src), opts)
+		if err != nil {
+			fmt.Printf("cannot upload %s -> %s: %v\n", src, targetUrl.String(), err)
+			os.Exit(1)
+		}
+		for _, entry := range report.Entries {
+			fmt.Printf("%-5s %s\n", entry.Action, entry.Path)
+		}
+		fmt.Printf("copied: %d, skipped: %d, %d bytes in %v\n", report.Count(ssh.DirActionCopy), report.Count(ssh.DirActionSkip), report.Bytes, report.Duration)
+		return
+	}
+
 	/* ProgressReader Bar */
 	uiprogress.Start()
 	bar := uiprogress.AddBar(100)
@@ -130,12 +171,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	rawurl := fmt.Sprintf("sftp://%s@%s:%s/%s", targetUser, targetHost, targetPort, targetPath)
-	targetUrl, err := url.Parse(rawurl)
-	if err != nil {
-		fmt.Printf("cannot parse url %s: %v\n", rawurl, err)
-		os.Exit(1)
-	}
 	//	shaSink := sha512.New()
 	//	dest := io.MultiWriter(w, shaSink)
 
@@ -151,3 +186,10 @@ func main() {
 	fmt.Printf("decrypt using openssl: \n openssl enc -aes-256-ctr -nosalt -d -in %s -out %s -K '%x' -iv '%x'\n", "encrypted.aes256", "plain.dat", key, iv)
 	fmt.Printf("target: %s\n", targetUrl.String())
 }
+
+func splitPatterns(patterns string) []string {
+	if patterns == "" {
+		return nil
+	}
+	return strings.Split(patterns, ",")
+}
@@ -4,11 +4,18 @@ import (
 	"flag"
 	"fmt"
 	"github.com/je4/utils/v2/pkg/zipasfolder"
+	"github.com/je4/utils/v2/pkg/zipasfolder/httpfs"
 	"io/fs"
+	"log"
+	"net/http"
 	"path/filepath"
+	"strings"
 )
 
 var basedir = flag.String("basedir", "", "The base directory to use for the zip file. (default: current directory)")
+var serve = flag.String("serve", "", "serve the tree via http on this address (i.e. localhost:8080) instead of printing it")
+var basePath = flag.String("basepath", "/", "base path of the http file server")
+var webDAVPath = flag.String("webdav", "", "base path of the read-only WebDAV endpoint (i.e. /dav). (default: disabled)")
 
 func recurseDir(fsys fs.FS, name string) {
 	files, err := fs.ReadDir(fsys, name)
@@ -30,12 +37,34 @@ func recurseDir(fsys fs.FS, name string) {
 	}
 }
 
+// muxPattern returns a subtree pattern for basePath
+func muxPattern(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return "/"
+	}
+	return "/" + basePath + "/"
+}
+
 func main() {
 	flag.Parse()
 
 	dirFS := zipasfolder.NewDummyOSRW(*basedir)
 	newFS := zipasfolder.NewFS(dirFS, 20)
-	defer newFS.Close()
+	defer newFS.(zipasfolder.FSRWClose).Close()
 
-	recurseDir(newFS, "")
+	if *serve == "" {
+		recurseDir(newFS, "")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(muxPattern(*basePath), httpfs.NewHandler(newFS, *basePath))
+	if *webDAVPath != "" {
+		mux.Handle(muxPattern(*webDAVPath), httpfs.NewWebDAVHandler(newFS, *webDAVPath))
+	}
+	log.Printf("serving '%s' on http://%s%s", *basedir, *serve, muxPattern(*basePath))
+	if err := http.ListenAndServe(*serve, mux); err != nil {
+		log.Printf("server stopped: %v", err)
+	}
 }
@@ -29,6 +29,7 @@ require (
 	github.com/stretchr/testify v1.11.1
 	github.com/tink-crypto/tink-go/v2 v2.6.0
 	github.com/tobischo/gokeepasslib/v3 v3.6.2
+	github.com/zeebo/xxh3 v1.1.0
 	go.elastic.co/apm/module/apmelasticsearch v1.15.0
 	go.ub.unibas.ch/cloud/certloader/v2 v2.0.24
 	golang.org/x/crypto v0.50.0
@@ -37,6 +38,7 @@ require (
 	google.golang.org/appengine v1.6.8
 	google.golang.org/grpc v1.80.0
 	gopkg.in/yaml.v3 v3.0.1
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
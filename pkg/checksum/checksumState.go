@@ -0,0 +1,183 @@
+package checksum
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"slices"
+
+	"emperror.dev/errors"
+)
+
+var checksumStateMagic = []byte("CSST\x01")
+
+// ChecksumState is a serializable snapshot of a ChecksumWriter.
+// It can be stored as binary blob (MarshalBinary) or as JSON.
+type ChecksumState struct {
+	Size   uint64                     `json:"size"`
+	States map[DigestAlgorithm][]byte `json:"states"`
+	// Unsupported are the algorithms of the writer without serializable state.
+	// They are not continued by NewChecksumWriterFromState
+	Unsupported []DigestAlgorithm `json:"unsupported,omitempty"`
+	// Chunks is the chunk state of a ChunkedChecksumWriter
+	Chunks *ChunkState `json:"chunks,omitempty"`
+}
+
+// ChunkState contains the completed chunk digests of a ChunkedChecksumWriter
+// and the hash state of the incomplete last chunk
+type ChunkState struct {
+	Algorithm DigestAlgorithm `json:"algorithm"`
+	BlockSize int64           `json:"blockSize"`
+	Chunks    []string        `json:"chunks"`
+	Partial   []byte          `json:"partial,omitempty"`
+}
+
+// Algorithms returns the sorted digest algorithms of the state
+func (s *ChecksumState) Algorithms() []DigestAlgorithm {
+	algs := make([]DigestAlgorithm, 0, len(s.States))
+	for alg := range s.States {
+		algs = append(algs, alg)
+	}
+	slices.Sort(algs)
+	return algs
+}
+
+func (s *ChecksumState) MarshalBinary() ([]byte, error) {
+	var data = slices.Clone(checksumStateMagic)
+	data = binary.AppendUvarint(data, s.Size)
+	data = binary.AppendUvarint(data, uint64(len(s.States)))
+	for _, alg := range s.Algorithms() {
+		data = binary.AppendUvarint(data, uint64(len(alg)))
+		data = append(data, alg...)
+		data = binary.AppendUvarint(data, uint64(len(s.States[alg])))
+		data = append(data, s.States[alg]...)
+	}
+	data = binary.AppendUvarint(data, uint64(len(s.Unsupported)))
+	for _, alg := range s.Unsupported {
+		data = binary.AppendUvarint(data, uint64(len(alg)))
+		data = append(data, alg...)
+	}
+	if s.Chunks == nil {
+		data = append(data, 0)
+		return data, nil
+	}
+	data = append(data, 1)
+	data = binary.AppendUvarint(data, uint64(len(s.Chunks.Algorithm)))
+	data = append(data, s.Chunks.Algorithm...)
+	data = binary.AppendUvarint(data, uint64(s.Chunks.BlockSize))
+	data = binary.AppendUvarint(data, uint64(len(s.Chunks.Chunks)))
+	for _, chunk := range s.Chunks.Chunks {
+		data = binary.AppendUvarint(data, uint64(len(chunk)))
+		data = append(data, chunk...)
+	}
+	data = binary.AppendUvarint(data, uint64(len(s.Chunks.Partial)))
+	data = append(data, s.Chunks.Partial...)
+	return data, nil
+}
+
+func (s *ChecksumState) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, checksumStateMagic) {
+		return errors.New("invalid checksum state header")
+	}
+	data = data[len(checksumStateMagic):]
+	var readUvarint = func() (uint64, error) {
+		val, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errors.New("invalid checksum state")
+		}
+		data = data[n:]
+		return val, nil
+	}
+	var readBytes = func() ([]byte, error) {
+		l, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)) < l {
+			return nil, errors.New("truncated checksum state")
+		}
+		val := slices.Clone(data[:l])
+		data = data[l:]
+		return val, nil
+	}
+	size, err := readUvarint()
+	if err != nil {
+		return err
+	}
+	count, err := readUvarint()
+	if err != nil {
+		return err
+	}
+	var states = map[DigestAlgorithm][]byte{}
+	for i := uint64(0); i < count; i++ {
+		alg, err := readBytes()
+		if err != nil {
+			return err
+		}
+		state, err := readBytes()
+		if err != nil {
+			return err
+		}
+		states[DigestAlgorithm(alg)] = state
+	}
+	count, err = readUvarint()
+	if err != nil {
+		return err
+	}
+	var unsupported []DigestAlgorithm
+	for i := uint64(0); i < count; i++ {
+		alg, err := readBytes()
+		if err != nil {
+			return err
+		}
+		unsupported = append(unsupported, DigestAlgorithm(alg))
+	}
+	if len(data) == 0 {
+		return errors.New("truncated checksum state")
+	}
+	hasChunks := data[0] == 1
+	data = data[1:]
+	var chunks *ChunkState
+	if hasChunks {
+		alg, err := readBytes()
+		if err != nil {
+			return err
+		}
+		blockSize, err := readUvarint()
+		if err != nil {
+			return err
+		}
+		count, err := readUvarint()
+		if err != nil {
+			return err
+		}
+		chunks = &ChunkState{Algorithm: DigestAlgorithm(alg), BlockSize: int64(blockSize), Chunks: []string{}}
+		for i := uint64(0); i < count; i++ {
+			chunk, err := readBytes()
+			if err != nil {
+				return err
+			}
+			chunks.Chunks = append(chunks.Chunks, string(chunk))
+		}
+		partial, err := readBytes()
+		if err != nil {
+			return err
+		}
+		if len(partial) > 0 {
+			chunks.Partial = partial
+		}
+	}
+	if len(data) != 0 {
+		return errors.New("trailing data in checksum state")
+	}
+	s.Size = size
+	s.States = states
+	s.Unsupported = unsupported
+	s.Chunks = chunks
+	return nil
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*ChecksumState)(nil)
+	_ encoding.BinaryUnmarshaler = (*ChecksumState)(nil)
+)
@@ -0,0 +1,119 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"hash"
+	"slices"
+	"testing"
+)
+
+func TestChecksumState(t *testing.T) {
+	var algs = []DigestAlgorithm{DigestSHA512, DigestSHA256, DigestMD5, DigestSize}
+	w, err := NewChecksumWriter(algs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Hello ")); err != nil {
+		t.Fatal(err)
+	}
+	// checkpoint while the writer is still open
+	data, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("World!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	original, err := w.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state = &ChecksumState{}
+	if err := state.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	// roundtrip via json
+	jsonData, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state = &ChecksumState{}
+	if err := json.Unmarshal(jsonData, state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Size != 6 {
+		t.Errorf("invalid state size %d", state.Size)
+	}
+
+	w2, err := NewChecksumWriterFromState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w2.Write([]byte("World!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w2.GetSize() != 12 {
+		t.Errorf("invalid size %d", w2.GetSize())
+	}
+	css, err := w2.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resultmap = map[DigestAlgorithm]string{
+		DigestMD5:    "ed076287532e86365e841e92bfc50d8c",
+		DigestSHA256: "7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069",
+		DigestSHA512: "861844d6704e8573fec34d967e20bcfef3d424cf48be04e6dc08f2bd58c729743371015ead891cc3cf1c9d34b49264b510751b1ff9e537937bc46b5d6ff4ecc8",
+		DigestSize:   "3132",
+	}
+	for key, val := range resultmap {
+		if css[key] != val {
+			t.Errorf("invalid result for '%s': %s != %s", key, css[key], val)
+		}
+		if original[key] != val {
+			t.Errorf("invalid result of uninterrupted writer for '%s': %s != %s", key, original[key], val)
+		}
+	}
+}
+
+// noStateHash hides the BinaryMarshaler of the embedded hash
+type noStateHash struct {
+	hash.Hash
+}
+
+func TestChecksumStateUnsupported(t *testing.T) {
+	const noState DigestAlgorithm = "test-nostate"
+	if !HashExists(noState) {
+		if err := RegisterDigest(noState, func() hash.Hash { return noStateHash{sha256.New()} }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := NewChecksumWriter([]DigestAlgorithm{DigestSHA256, noState})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("Hello ")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state = &ChecksumState{}
+	if err := state.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(state.Algorithms(), []DigestAlgorithm{DigestSHA256}) {
+		t.Errorf("invalid algorithms %v", state.Algorithms())
+	}
+	if !slices.Equal(state.Unsupported, []DigestAlgorithm{noState}) {
+		t.Errorf("invalid unsupported algorithms %v", state.Unsupported)
+	}
+}
@@ -1,13 +1,25 @@
 package checksum
 
 import (
+	"context"
+	"encoding"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+
 	"emperror.dev/errors"
 	"github.com/je4/utils/v2/pkg/concurrentWriter"
-	"io"
 )
 
 type ChecksumWriter struct {
 	writer *concurrentWriter.ConcurrentWriter
+	size   atomic.Uint64
+	closed atomic.Bool
+	// start is the size of a restored state
+	start uint64
+	// writeLock keeps writes out while GetState takes a snapshot
+	writeLock sync.Mutex
 }
 
 func NewChecksumWriter(checksums []DigestAlgorithm, writers ...io.Writer) (*ChecksumWriter, error) {
@@ -19,28 +31,61 @@ func NewChecksumWriter(checksums []DigestAlgorithm, writers ...io.Writer) (*Chec
 		}
 		runners = append(runners, runner)
 	}
-	writer := concurrentWriter.NewConcurrentWriter(runners, writers...)
+	return newChecksumWriter(runners, 0, writers...), nil
+}
+
+// NewChecksumWriterFromState creates a ChecksumWriter which continues hashing where the writer
+// of the state stopped. The caller has to continue writing at offset state.Size of the source.
+func NewChecksumWriterFromState(state *ChecksumState, writers ...io.Writer) (*ChecksumWriter, error) {
+	var runners = []concurrentWriter.WriterRunner{}
+	for _, alg := range state.Algorithms() {
+		runner, err := NewWriterRunnerChecksumFromState(alg, state.States[alg])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot restore runner for '%s'", alg)
+		}
+		runners = append(runners, runner)
+	}
+	return newChecksumWriter(runners, state.Size, writers...), nil
+}
+
+func newChecksumWriter(runners []concurrentWriter.WriterRunner, size uint64, writers ...io.Writer) *ChecksumWriter {
+	writer := concurrentWriter.NewConcurrentWriter(context.Background(), runners, writers...)
 	c := &ChecksumWriter{
 		writer: writer,
+		start:  size,
 	}
-	return c, nil
+	c.size.Store(size)
+	return c
 }
 
 func (c *ChecksumWriter) Write(p []byte) (n int, err error) {
-	return c.writer.Write(p)
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	n, err = c.writer.Write(p)
+	c.size.Add(uint64(n))
+	return n, err
 }
 
 func (c *ChecksumWriter) Close() error {
+	c.closed.Store(true)
 	if err := c.writer.Close(); err != nil {
 		return errors.Wrap(err, "cannot close concurrent writer")
 	}
 	return nil
 }
 
+// GetSize returns the number of bytes hashed so far, including the bytes of a restored state
+func (c *ChecksumWriter) GetSize() uint64 {
+	return c.size.Load()
+}
+
 func (c *ChecksumWriter) GetChecksums() (map[DigestAlgorithm]string, error) {
 	var result = map[DigestAlgorithm]string{}
 	for _, runner := range c.writer.GetRunners() {
-		r := runner.(*WriterRunnerChecksum)
+		r, ok := runner.(*WriterRunnerChecksum)
+		if !ok {
+			continue
+		}
 		digest, err := r.GetDigest()
 		if err != nil {
 			return nil, errors.Wrapf(err, "cannot get digest from '%s'", r.GetAlgorithm())
@@ -50,6 +95,55 @@ func (c *ChecksumWriter) GetChecksums() (map[DigestAlgorithm]string, error) {
 	return result, nil
 }
 
+// GetState returns a snapshot of all hash states with the data written so far.
+// It can be called while writing, e.g. to checkpoint a long running transfer.
+// Algorithms which cannot serialize their state are listed in ChecksumState.Unsupported
+func (c *ChecksumWriter) GetState() (*ChecksumState, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.writer.GetError(); err != nil {
+		return nil, errors.Wrap(err, "cannot get state of failed checksum writer")
+	}
+	var state = &ChecksumState{
+		Size:   c.size.Load(),
+		States: map[DigestAlgorithm][]byte{},
+	}
+	for _, runner := range c.writer.GetRunners() {
+		if r, ok := runner.(*WriterRunnerChunks); ok {
+			chunks, err := r.Snapshot(state.Size - c.start)
+			if err != nil {
+				return nil, errors.Wrap(err, "cannot get chunk state")
+			}
+			state.Chunks = chunks
+			continue
+		}
+		r, ok := runner.(*WriterRunnerChecksum)
+		if !ok {
+			return nil, errors.Errorf("runner '%s' does not support state serialization", runner.GetName())
+		}
+		data, err := r.Snapshot(state.Size - c.start)
+		if errors.Is(err, ErrStateNotSupported) {
+			state.Unsupported = append(state.Unsupported, r.GetAlgorithm())
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get state from '%s'", r.GetAlgorithm())
+		}
+		state.States[r.GetAlgorithm()] = data
+	}
+	slices.Sort(state.Unsupported)
+	return state, nil
+}
+
+func (c *ChecksumWriter) MarshalBinary() ([]byte, error) {
+	state, err := c.GetState()
+	if err != nil {
+		return nil, err
+	}
+	return state.MarshalBinary()
+}
+
 var (
-	_ io.WriteCloser = (*ChecksumWriter)(nil)
+	_ io.WriteCloser           = (*ChecksumWriter)(nil)
+	_ encoding.BinaryMarshaler = (*ChecksumWriter)(nil)
 )
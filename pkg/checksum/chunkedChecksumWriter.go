@@ -0,0 +1,409 @@
+package checksum
+
+import (
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/concurrentWriter"
+)
+
+// ChunkList contains the digests of consecutive blocks of a file and the Merkle root over them.
+// Leaves are computed as H(0x00 || chunk digest) and inner nodes as H(0x01 || left || right),
+// so that a leaf can never be mistaken for an inner node. An odd node is promoted unchanged
+// to the next level.
+type ChunkList struct {
+	Algorithm DigestAlgorithm `json:"algorithm"`
+	BlockSize int64           `json:"blockSize"`
+	Size      int64           `json:"size"`
+	Chunks    []string        `json:"chunks"`
+	Root      string          `json:"root"`
+}
+
+// ChunkMismatchError is returned if the data of a chunk does not match the chunk list
+type ChunkMismatchError struct {
+	Index    int
+	Offset   int64
+	Expected string
+	Actual   string
+}
+
+func (e *ChunkMismatchError) Error() string {
+	return fmt.Sprintf("chunk %d at offset %d: digest mismatch (expected %s, got %s)", e.Index, e.Offset, e.Expected, e.Actual)
+}
+
+// MerkleRoot computes the Merkle root over the chunk digests
+func (cl *ChunkList) MerkleRoot() (string, error) {
+	var level = make([][]byte, 0, len(cl.Chunks))
+	for i, chunk := range cl.Chunks {
+		digest, err := hex.DecodeString(chunk)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid digest of chunk %d", i)
+		}
+		sink, err := GetHash(cl.Algorithm)
+		if err != nil {
+			return "", err
+		}
+		sink.Write([]byte{0x00})
+		sink.Write(digest)
+		level = append(level, sink.Sum(nil))
+	}
+	if len(level) == 0 {
+		sink, err := GetHash(cl.Algorithm)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%x", sink.Sum(nil)), nil
+	}
+	for len(level) > 1 {
+		var next = make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			sink, err := GetHash(cl.Algorithm)
+			if err != nil {
+				return "", err
+			}
+			sink.Write([]byte{0x01})
+			sink.Write(level[i])
+			sink.Write(level[i+1])
+			next = append(next, sink.Sum(nil))
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0]), nil
+}
+
+// Check verifies that the chunk list is consistent with its size and Merkle root
+func (cl *ChunkList) Check() error {
+	if cl.BlockSize <= 0 {
+		return errors.Errorf("invalid block size %d", cl.BlockSize)
+	}
+	if expected := (cl.Size + cl.BlockSize - 1) / cl.BlockSize; int64(len(cl.Chunks)) != expected {
+		return errors.Errorf("invalid number of chunks %d, expected %d", len(cl.Chunks), expected)
+	}
+	root, err := cl.MerkleRoot()
+	if err != nil {
+		return errors.Wrap(err, "cannot compute merkle root")
+	}
+	if root != cl.Root {
+		return errors.Errorf("merkle root mismatch (expected %s, got %s)", cl.Root, root)
+	}
+	return nil
+}
+
+// VerifyRange checks all chunks which overlap the byte range [offset, offset+length) of r.
+// r must provide the whole file, since chunks are always verified completely.
+func (cl *ChunkList) VerifyRange(r io.ReaderAt, offset, length int64) error {
+	if cl.BlockSize <= 0 {
+		return errors.Errorf("invalid block size %d", cl.BlockSize)
+	}
+	if offset < 0 || length < 0 || offset+length > cl.Size {
+		return errors.Errorf("range %d+%d outside of file size %d", offset, length, cl.Size)
+	}
+	if length == 0 {
+		return nil
+	}
+	first := int(offset / cl.BlockSize)
+	last := int((offset + length - 1) / cl.BlockSize)
+	for i := first; i <= last; i++ {
+		if err := cl.VerifyChunk(r, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyChunk checks the chunk with the given index against the data of r
+func (cl *ChunkList) VerifyChunk(r io.ReaderAt, index int) error {
+	if index < 0 || index >= len(cl.Chunks) {
+		return errors.Errorf("chunk index %d out of range", index)
+	}
+	start := int64(index) * cl.BlockSize
+	size := min(cl.BlockSize, cl.Size-start)
+	sink, err := GetHash(cl.Algorithm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sink, io.NewSectionReader(r, start, size)); err != nil {
+		return errors.Wrapf(err, "cannot read chunk %d", index)
+	}
+	actual := fmt.Sprintf("%x", sink.Sum(nil))
+	if actual != cl.Chunks[index] {
+		return &ChunkMismatchError{
+			Index:    index,
+			Offset:   start,
+			Expected: cl.Chunks[index],
+			Actual:   actual,
+		}
+	}
+	return nil
+}
+
+// WriterRunnerChunks is a WriterRunner, which creates a ChunkList of the data
+type WriterRunnerChunks struct {
+	alg       DigestAlgorithm
+	blockSize int64
+	chunks    *ChunkList
+	errors    []error
+	errorLock sync.Mutex
+	// stateLock protects the fields below
+	stateLock sync.Mutex
+	stateCond *sync.Cond
+	digests   []string
+	current   hash.Hash
+	currentN  int64
+	hashed    uint64
+	finished  bool
+}
+
+func NewWriterRunnerChunks(alg DigestAlgorithm, blockSize int64) (*WriterRunnerChunks, error) {
+	if !HashExists(alg) {
+		return nil, errors.Errorf("unknown hash algorithm '%s'", alg)
+	}
+	if blockSize <= 0 {
+		return nil, errors.Errorf("invalid block size %d", blockSize)
+	}
+	current, err := GetHash(alg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hash function %s", alg)
+	}
+	w := &WriterRunnerChunks{
+		alg:       alg,
+		blockSize: blockSize,
+		errorLock: sync.Mutex{},
+		digests:   []string{},
+		current:   current,
+	}
+	w.stateCond = sync.NewCond(&w.stateLock)
+	return w, nil
+}
+
+// NewWriterRunnerChunksFromState creates a runner which continues the chunk list of state.
+// size is the number of bytes hashed into state
+func NewWriterRunnerChunksFromState(state *ChunkState, size uint64) (*WriterRunnerChunks, error) {
+	w, err := NewWriterRunnerChunks(state.Algorithm, state.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	full := uint64(len(state.Chunks)) * uint64(state.BlockSize)
+	if size < full || size-full >= uint64(state.BlockSize) {
+		return nil, errors.Errorf("%d chunks of %d bytes do not match size %d", len(state.Chunks), state.BlockSize, size)
+	}
+	w.digests = slices.Clone(state.Chunks)
+	w.currentN = int64(size - full)
+	if w.currentN > 0 {
+		u, ok := w.current.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return nil, errors.Wrapf(ErrStateNotSupported, "hash function %s", state.Algorithm)
+		}
+		if err := u.UnmarshalBinary(state.Partial); err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal state of %s", state.Algorithm)
+		}
+	}
+	return w, nil
+}
+
+func (w *WriterRunnerChunks) setError(err error) {
+	w.errorLock.Lock()
+	defer w.errorLock.Unlock()
+	w.errors = append(w.errors, err)
+}
+
+// write hashes p into the chunks. The caller holds stateLock
+func (w *WriterRunnerChunks) write(p []byte) error {
+	for len(p) > 0 {
+		n := min(int64(len(p)), w.blockSize-w.currentN)
+		w.current.Write(p[:n])
+		w.currentN += n
+		p = p[n:]
+		if w.currentN == w.blockSize {
+			w.digests = append(w.digests, fmt.Sprintf("%x", w.current.Sum(nil)))
+			sink, err := GetHash(w.alg)
+			if err != nil {
+				return errors.Wrapf(err, "invalid hash function %s", w.alg)
+			}
+			w.current = sink
+			w.currentN = 0
+		}
+	}
+	return nil
+}
+
+func (w *WriterRunnerChunks) Do(reader io.Reader, done chan bool) {
+	// we should end in all cases
+	defer func() {
+		done <- true
+	}()
+	defer func() {
+		w.stateLock.Lock()
+		w.finished = true
+		w.stateCond.Broadcast()
+		w.stateLock.Unlock()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			w.stateLock.Lock()
+			werr := w.write(buf[:n])
+			w.hashed += uint64(n)
+			w.stateCond.Broadcast()
+			w.stateLock.Unlock()
+			if werr != nil {
+				w.setError(werr)
+				io.Copy(&NullWriter{}, reader)
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.setError(errors.Wrapf(err, "cannot create chunk checksum %s", w.alg))
+			return
+		}
+	}
+
+	w.stateLock.Lock()
+	var chunks = &ChunkList{
+		Algorithm: w.alg,
+		BlockSize: w.blockSize,
+		Size:      int64(len(w.digests))*w.blockSize + w.currentN,
+		Chunks:    slices.Clone(w.digests),
+	}
+	if w.currentN > 0 {
+		chunks.Chunks = append(chunks.Chunks, fmt.Sprintf("%x", w.current.Sum(nil)))
+	}
+	w.stateLock.Unlock()
+	root, err := chunks.MerkleRoot()
+	if err != nil {
+		w.setError(errors.Wrap(err, "cannot create merkle root"))
+		return
+	}
+	chunks.Root = root
+	w.errorLock.Lock()
+	w.chunks = chunks
+	w.errorLock.Unlock()
+}
+
+// Snapshot waits until the runner has hashed size bytes and returns the completed chunks
+// together with the hash state of the incomplete chunk
+func (w *WriterRunnerChunks) Snapshot(size uint64) (*ChunkState, error) {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+	for w.hashed < size && !w.finished {
+		w.stateCond.Wait()
+	}
+	if w.hashed < size {
+		if err := w.GetError(); err != nil {
+			return nil, errors.Wrapf(err, "cannot get chunk state of %s", w.alg)
+		}
+		return nil, errors.Errorf("chunks %s finished after %d of %d bytes", w.alg, w.hashed, size)
+	}
+	state := &ChunkState{
+		Algorithm: w.alg,
+		BlockSize: w.blockSize,
+		Chunks:    slices.Clone(w.digests),
+	}
+	if w.currentN > 0 {
+		m, ok := w.current.(encoding.BinaryMarshaler)
+		if !ok {
+			return nil, errors.Wrapf(ErrStateNotSupported, "hash function %s", w.alg)
+		}
+		partial, err := m.MarshalBinary()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot marshal state of %s", w.alg)
+		}
+		state.Partial = partial
+	}
+	return state, nil
+}
+
+func (w *WriterRunnerChunks) GetName() string {
+	return fmt.Sprintf("WriterRunnerChunks_%s_%d", w.alg, w.blockSize)
+}
+
+func (w *WriterRunnerChunks) GetChunkList() (*ChunkList, error) {
+	w.errorLock.Lock()
+	defer w.errorLock.Unlock()
+	return w.chunks, errors.Combine(w.errors...)
+}
+
+func (w *WriterRunnerChunks) GetError() error {
+	w.errorLock.Lock()
+	defer w.errorLock.Unlock()
+	return errors.Combine(w.errors...)
+}
+
+// ChunkedChecksumWriter creates the whole-file digests of a ChecksumWriter together
+// with a ChunkList of blockSize chunks. Its state contains the chunks as well
+type ChunkedChecksumWriter struct {
+	*ChecksumWriter
+	chunkRunner *WriterRunnerChunks
+}
+
+func NewChunkedChecksumWriter(checksums []DigestAlgorithm, chunkAlg DigestAlgorithm, blockSize int64, writers ...io.Writer) (*ChunkedChecksumWriter, error) {
+	chunkRunner, err := NewWriterRunnerChunks(chunkAlg, blockSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create chunk runner for '%s'", chunkAlg)
+	}
+	var runners = []concurrentWriter.WriterRunner{chunkRunner}
+	for _, alg := range checksums {
+		runner, err := NewWriterRunnerChecksum(alg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot create runner for '%s'", alg)
+		}
+		runners = append(runners, runner)
+	}
+	return &ChunkedChecksumWriter{
+		ChecksumWriter: newChecksumWriter(runners, 0, writers...),
+		chunkRunner:    chunkRunner,
+	}, nil
+}
+
+// NewChunkedChecksumWriterFromState continues a ChunkedChecksumWriter from a state with chunks
+func NewChunkedChecksumWriterFromState(state *ChecksumState, writers ...io.Writer) (*ChunkedChecksumWriter, error) {
+	if state.Chunks == nil {
+		return nil, errors.New("checksum state does not contain chunks")
+	}
+	chunkRunner, err := NewWriterRunnerChunksFromState(state.Chunks, state.Size)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot restore chunk runner")
+	}
+	var runners = []concurrentWriter.WriterRunner{chunkRunner}
+	for _, alg := range state.Algorithms() {
+		runner, err := NewWriterRunnerChecksumFromState(alg, state.States[alg])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot restore runner for '%s'", alg)
+		}
+		runners = append(runners, runner)
+	}
+	return &ChunkedChecksumWriter{
+		ChecksumWriter: newChecksumWriter(runners, state.Size, writers...),
+		chunkRunner:    chunkRunner,
+	}, nil
+}
+
+// GetChunkList returns the chunk digests and merkle root. The writer must be closed before.
+func (c *ChunkedChecksumWriter) GetChunkList() (*ChunkList, error) {
+	if !c.closed.Load() {
+		return nil, errors.New("cannot get chunk list of open writer")
+	}
+	return c.chunkRunner.GetChunkList()
+}
+
+var (
+	_ concurrentWriter.WriterRunner      = (*WriterRunnerChunks)(nil)
+	_ concurrentWriter.WriterRunnerError = (*WriterRunnerChunks)(nil)
+	_ io.WriteCloser                     = (*ChunkedChecksumWriter)(nil)
+	_ encoding.BinaryMarshaler           = (*ChunkedChecksumWriter)(nil)
+)
@@ -0,0 +1,128 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestChunkedChecksumWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	w, err := NewChunkedChecksumWriter([]DigestAlgorithm{DigestSHA256}, DigestSHA256, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	css, err := w.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := Checksum(bytes.NewReader(data), DigestSHA256); css[DigestSHA256] != expected {
+		t.Errorf("invalid whole file digest: %s != %s", css[DigestSHA256], expected)
+	}
+	cl, err := w.GetChunkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cl.Chunks) != 16 || cl.Size != 1000 {
+		t.Fatalf("invalid chunk list: %d chunks, size %d", len(cl.Chunks), cl.Size)
+	}
+	if err := cl.Check(); err != nil {
+		t.Error(err)
+	}
+	if err := cl.VerifyRange(bytes.NewReader(data), 100, 500); err != nil {
+		t.Error(err)
+	}
+
+	corrupt := bytes.Clone(data)
+	corrupt[970] = 'x'
+	if err := cl.VerifyRange(bytes.NewReader(corrupt), 0, 900); err != nil {
+		t.Errorf("range without corruption failed: %v", err)
+	}
+	err = cl.VerifyRange(bytes.NewReader(corrupt), 900, 100)
+	var mismatch *ChunkMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ChunkMismatchError, got %v", err)
+	}
+	if mismatch.Index != 15 {
+		t.Errorf("invalid mismatch index %d", mismatch.Index)
+	}
+}
+
+func TestChunkedChecksumWriterState(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	w, err := NewChunkedChecksumWriter([]DigestAlgorithm{DigestSHA256}, DigestSHA256, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data[:100]); err != nil {
+		t.Fatal(err)
+	}
+	// checkpoint in the middle of the second chunk
+	stateData, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data[100:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := w.GetChunkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state = &ChecksumState{}
+	if err := state.UnmarshalBinary(stateData); err != nil {
+		t.Fatal(err)
+	}
+	if state.Chunks == nil || len(state.Chunks.Chunks) != 1 {
+		t.Fatalf("invalid chunk state %+v", state.Chunks)
+	}
+	w2, err := NewChunkedChecksumWriterFromState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w2.Write(data[100:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cl, err := w2.GetChunkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Root != expected.Root || cl.Size != expected.Size || len(cl.Chunks) != len(expected.Chunks) {
+		t.Errorf("restored chunk list differs: %+v != %+v", cl, expected)
+	}
+	css, err := w2.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest, _ := Checksum(bytes.NewReader(data), DigestSHA256); css[DigestSHA256] != digest {
+		t.Errorf("invalid whole file digest after restore: %s != %s", css[DigestSHA256], digest)
+	}
+}
+
+func TestMerkleLeafPrefix(t *testing.T) {
+	digest := sha256.Sum256([]byte("chunk"))
+	cl := &ChunkList{Algorithm: DigestSHA256, Chunks: []string{hex.EncodeToString(digest[:])}}
+	root, err := cl.MerkleRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := sha256.Sum256(append([]byte{0x00}, digest[:]...))
+	if root != hex.EncodeToString(leaf[:]) {
+		t.Errorf("leaf without 0x00 prefix: %s", root)
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha3"
 	"crypto/sha512"
 	"fmt"
 	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"slices"
+	"sync"
 
 	"emperror.dev/errors"
+	"github.com/zeebo/xxh3"
 	"golang.org/x/crypto/blake2b"
-	"golang.org/x/exp/maps"
+	"lukechampine.com/blake3"
 )
 
 type DigestAlgorithm string
@@ -20,7 +26,7 @@ func (d DigestAlgorithm) String() string {
 }
 
 func (d *DigestAlgorithm) UnmarshalText(text []byte) error {
-	if _, ok := hashFunc[DigestAlgorithm(string(text))]; !ok {
+	if !HashExists(DigestAlgorithm(text)) {
 		return errors.Errorf("invalid digest %s", text)
 	}
 	*d = DigestAlgorithm(text)
@@ -32,18 +38,45 @@ const (
 	DigestSHA1       DigestAlgorithm = "sha1"
 	DigestSHA256     DigestAlgorithm = "sha256"
 	DigestSHA512     DigestAlgorithm = "sha512"
+	DigestSHA512_224 DigestAlgorithm = "sha512-224"
+	DigestSHA512_256 DigestAlgorithm = "sha512-256"
+	DigestSHA3_224   DigestAlgorithm = "sha3-224"
+	DigestSHA3_256   DigestAlgorithm = "sha3-256"
+	DigestSHA3_384   DigestAlgorithm = "sha3-384"
+	DigestSHA3_512   DigestAlgorithm = "sha3-512"
 	DigestBlake2b160 DigestAlgorithm = "blake2b-160"
 	DigestBlake2b256 DigestAlgorithm = "blake2b-256"
 	DigestBlake2b384 DigestAlgorithm = "blake2b-384"
 	DigestBlake2b512 DigestAlgorithm = "blake2b-512"
+	DigestBlake3_256 DigestAlgorithm = "blake3-256"
+	DigestBlake3_512 DigestAlgorithm = "blake3-512"
+	DigestCRC32      DigestAlgorithm = "crc32"
+	DigestCRC32C     DigestAlgorithm = "crc32c"
+	DigestCRC64ISO   DigestAlgorithm = "crc64-iso"
+	DigestCRC64ECMA  DigestAlgorithm = "crc64-ecma"
+	DigestXXH3       DigestAlgorithm = "xxh3"
 	DigestSize       DigestAlgorithm = "size"
 )
 
+var (
+	crc32cTable    = crc32.MakeTable(crc32.Castagnoli)
+	crc64ISOTable  = crc64.MakeTable(crc64.ISO)
+	crc64ECMATable = crc64.MakeTable(crc64.ECMA)
+)
+
+var hashFuncLock sync.RWMutex
+
 var hashFunc = map[DigestAlgorithm]func() hash.Hash{
-	DigestMD5:    md5.New,
-	DigestSHA1:   sha1.New,
-	DigestSHA256: sha256.New,
-	DigestSHA512: sha512.New,
+	DigestMD5:        md5.New,
+	DigestSHA1:       sha1.New,
+	DigestSHA256:     sha256.New,
+	DigestSHA512:     sha512.New,
+	DigestSHA512_224: sha512.New512_224,
+	DigestSHA512_256: sha512.New512_256,
+	DigestSHA3_224:   func() hash.Hash { return sha3.New224() },
+	DigestSHA3_256:   func() hash.Hash { return sha3.New256() },
+	DigestSHA3_384:   func() hash.Hash { return sha3.New384() },
+	DigestSHA3_512:   func() hash.Hash { return sha3.New512() },
 	DigestBlake2b160: func() hash.Hash {
 		h, err := blake2b.New(20, nil)
 		if err != nil {
@@ -72,18 +105,70 @@ var hashFunc = map[DigestAlgorithm]func() hash.Hash{
 		}
 		return h
 	},
-	DigestSize: NewSizeHash,
+	DigestBlake3_256: func() hash.Hash { return blake3.New(32, nil) },
+	DigestBlake3_512: func() hash.Hash { return blake3.New(64, nil) },
+	DigestCRC32:      func() hash.Hash { return crc32.NewIEEE() },
+	DigestCRC32C:     func() hash.Hash { return crc32.New(crc32cTable) },
+	DigestCRC64ISO:   func() hash.Hash { return crc64.New(crc64ISOTable) },
+	DigestCRC64ECMA:  func() hash.Hash { return crc64.New(crc64ECMATable) },
+	DigestXXH3:       func() hash.Hash { return xxh3.New() },
+	DigestSize:       NewSizeHash,
+}
+
+// RegisterDigest makes a hash function available under the given name for GetHash, HashExists,
+// RegisteredDigests and DigestAlgorithm.UnmarshalText. It is safe for concurrent use with these functions,
+// but not with reads of DigestNames. Registering a name twice returns an error.
+func RegisterDigest(name DigestAlgorithm, factory func() hash.Hash) error {
+	if name == "" {
+		return errors.New("digest name must not be empty")
+	}
+	if factory == nil {
+		return errors.Errorf("no factory for digest '%s'", name)
+	}
+	hashFuncLock.Lock()
+	defer hashFuncLock.Unlock()
+	if _, ok := hashFunc[name]; ok {
+		return errors.Errorf("digest '%s' already registered", name)
+	}
+	hashFunc[name] = factory
+	DigestNames = digestNames()
+	return nil
 }
 
-var DigestNames = maps.Keys(hashFunc)
+// DigestNames contains the sorted names of all registered digest algorithms.
+//
+// Deprecated: RegisterDigest replaces it without synchronization, so it is only safe to read before any
+// RegisterDigest call. Use RegisteredDigests.
+var DigestNames = digestNames()
+
+// digestNames returns the sorted keys of hashFunc. The caller holds hashFuncLock
+func digestNames() []DigestAlgorithm {
+	names := make([]DigestAlgorithm, 0, len(hashFunc))
+	for name := range hashFunc {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// RegisteredDigests returns the sorted names of all registered digest algorithms
+func RegisteredDigests() []DigestAlgorithm {
+	hashFuncLock.RLock()
+	defer hashFuncLock.RUnlock()
+	return digestNames()
+}
 
 func HashExists(csType DigestAlgorithm) bool {
+	hashFuncLock.RLock()
+	defer hashFuncLock.RUnlock()
 	_, ok := hashFunc[csType]
 	return ok
 }
 
 func GetHash(csType DigestAlgorithm) (hash.Hash, error) {
+	hashFuncLock.RLock()
 	f, ok := hashFunc[csType]
+	hashFuncLock.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("unknown checksum %s", csType)
 	}
@@ -0,0 +1,54 @@
+package checksum
+
+import (
+	"bytes"
+	"hash"
+	"hash/fnv"
+	"slices"
+	"testing"
+)
+
+func TestDigestRegistry(t *testing.T) {
+	var resultmap = map[DigestAlgorithm]string{
+		DigestSHA3_256:   "d0e47486bbf4c16acac26f8b653592973c1362909f90262877089f9c8a4536af",
+		DigestSHA512_256: "f371319eee6b39b058ec262d4e723a26710e46761301c8b54c56fa722267581a",
+		DigestCRC32:      "1c291ca3",
+		DigestCRC32C:     "fe6cf1dc",
+	}
+	for alg, expected := range resultmap {
+		cs, err := Checksum(bytes.NewReader([]byte("Hello World!")), alg)
+		if err != nil {
+			t.Fatalf("cannot create checksum '%s': %v", alg, err)
+		}
+		if cs != expected {
+			t.Errorf("invalid result for '%s': %s != %s", alg, cs, expected)
+		}
+	}
+
+	const fnvAlg DigestAlgorithm = "fnv1a-64"
+	if err := RegisterDigest(fnvAlg, func() hash.Hash { return fnv.New64a() }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterDigest(fnvAlg, func() hash.Hash { return fnv.New64a() }); err == nil {
+		t.Errorf("duplicate registration of '%s' not detected", fnvAlg)
+	}
+	if err := RegisterDigest(DigestSHA256, func() hash.Hash { return fnv.New64a() }); err == nil {
+		t.Errorf("overriding builtin '%s' not detected", DigestSHA256)
+	}
+	if !HashExists(fnvAlg) {
+		t.Errorf("'%s' not found", fnvAlg)
+	}
+	if !slices.Contains(RegisteredDigests(), fnvAlg) {
+		t.Errorf("'%s' missing in RegisteredDigests", fnvAlg)
+	}
+	if !slices.Contains(DigestNames, fnvAlg) {
+		t.Errorf("'%s' missing in DigestNames", fnvAlg)
+	}
+	var d DigestAlgorithm
+	if err := d.UnmarshalText([]byte(fnvAlg)); err != nil {
+		t.Error(err)
+	}
+	if err := d.UnmarshalText([]byte("unknown")); err == nil {
+		t.Error("unknown digest accepted")
+	}
+}
@@ -0,0 +1,346 @@
+package checksum
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+)
+
+type ManifestFormat string
+
+const (
+	// ManifestFormatGNU is the format of GNU coreutils sha256sum & co: "<digest>  <path>"
+	ManifestFormatGNU ManifestFormat = "gnu"
+	// ManifestFormatBSD is the tagged format of BSD and "sha256sum --tag": "SHA256 (<path>) = <digest>"
+	ManifestFormatBSD ManifestFormat = "bsd"
+	// ManifestFormatBagIt is the payload manifest format of BagIt (RFC 8493): "<digest> <path>"
+	ManifestFormatBagIt ManifestFormat = "bagit"
+)
+
+func (f *ManifestFormat) UnmarshalText(text []byte) error {
+	switch format := ManifestFormat(strings.ToLower(string(text))); format {
+	case ManifestFormatGNU, ManifestFormatBSD, ManifestFormatBagIt:
+		*f = format
+		return nil
+	default:
+		return errors.Errorf("invalid manifest format %s", text)
+	}
+}
+
+// Manifest maps slash separated file paths to their digests
+type Manifest map[string]map[DigestAlgorithm]string
+
+// Paths returns the sorted paths of the manifest
+func (m Manifest) Paths() []string {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Add merges the digests of another manifest into m
+func (m Manifest) Add(other Manifest) {
+	for p, digests := range other {
+		if _, ok := m[p]; !ok {
+			m[p] = map[DigestAlgorithm]string{}
+		}
+		for alg, digest := range digests {
+			m[p][alg] = digest
+		}
+	}
+}
+
+// Write writes the manifest in the given format.
+// GNU and BagIt manifests contain exactly one algorithm, BSD manifests may contain several.
+func (m Manifest) Write(w io.Writer, format ManifestFormat, algs ...DigestAlgorithm) error {
+	if len(algs) == 0 {
+		return errors.New("no digest algorithm given")
+	}
+	if format != ManifestFormatBSD && len(algs) > 1 {
+		return errors.Errorf("%s manifest supports only one digest algorithm", format)
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range m.Paths() {
+		for _, alg := range algs {
+			digest, ok := m[p][alg]
+			if !ok {
+				return errors.Errorf("no '%s' digest for '%s'", alg, p)
+			}
+			var line string
+			switch format {
+			case ManifestFormatGNU:
+				if strings.ContainsAny(p, "\\\n\r") {
+					line = fmt.Sprintf("\\%s  %s\n", digest, gnuEscaper.Replace(p))
+				} else {
+					line = fmt.Sprintf("%s  %s\n", digest, p)
+				}
+			case ManifestFormatBSD:
+				line = fmt.Sprintf("%s (%s) = %s\n", strings.ToUpper(string(alg)), p, digest)
+			case ManifestFormatBagIt:
+				line = fmt.Sprintf("%s %s\n", digest, bagitEscaper.Replace(p))
+			default:
+				return errors.Errorf("unknown manifest format '%s'", format)
+			}
+			if _, err := bw.WriteString(line); err != nil {
+				return errors.Wrapf(err, "cannot write manifest entry for '%s'", p)
+			}
+		}
+	}
+	return errors.Wrap(bw.Flush(), "cannot write manifest")
+}
+
+var (
+	gnuEscaper     = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+	gnuUnescaper   = strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+	bagitEscaper   = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D")
+	bagitUnescaper = strings.NewReplacer("%25", "%", "%0A", "\n", "%0a", "\n", "%0D", "\r", "%0d", "\r")
+	bsdLineRegexp  = regexp.MustCompile(`^\\?([^ ]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+)
+
+// ReadManifest parses a manifest in the given format.
+// For GNU and BagIt manifests alg names the digest algorithm, BSD manifests carry it on every line.
+func ReadManifest(r io.Reader, format ManifestFormat, alg DigestAlgorithm) (Manifest, error) {
+	var m = Manifest{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lineNo int
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var p, digest string
+		var lineAlg = alg
+		switch format {
+		case ManifestFormatGNU:
+			escaped := strings.HasPrefix(line, "\\")
+			if escaped {
+				line = line[1:]
+			}
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 || len(parts[1]) < 1 || (parts[1][0] != ' ' && parts[1][0] != '*') {
+				return nil, errors.Errorf("invalid gnu manifest line %d: %s", lineNo, line)
+			}
+			digest, p = parts[0], parts[1][1:]
+			if escaped {
+				p = gnuUnescaper.Replace(p)
+			}
+		case ManifestFormatBSD:
+			matches := bsdLineRegexp.FindStringSubmatch(line)
+			if matches == nil {
+				return nil, errors.Errorf("invalid bsd manifest line %d: %s", lineNo, line)
+			}
+			lineAlg, p, digest = DigestAlgorithm(strings.ToLower(matches[1])), matches[2], matches[3]
+		case ManifestFormatBagIt:
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("invalid bagit manifest line %d: %s", lineNo, line)
+			}
+			digest, p = parts[0], bagitUnescaper.Replace(strings.TrimLeft(parts[1], " \t"))
+		default:
+			return nil, errors.Errorf("unknown manifest format '%s'", format)
+		}
+		if lineAlg == "" {
+			return nil, errors.Errorf("no digest algorithm for %s manifest", format)
+		}
+		p = path.Clean(strings.TrimPrefix(p, "./"))
+		if _, ok := m[p]; !ok {
+			m[p] = map[DigestAlgorithm]string{}
+		}
+		m[p][lineAlg] = strings.ToLower(digest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot read manifest")
+	}
+	return m, nil
+}
+
+// CreateManifest walks root within fsys and computes all checksums of every regular file in one pass per file.
+// The resulting paths are relative to fsys. If workers is less than 1, runtime.NumCPU() workers are used.
+func CreateManifest(fsys fs.FS, root string, checksums []DigestAlgorithm, workers int) (Manifest, error) {
+	paths, err := manifestWalk(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	var m = Manifest{}
+	var lock sync.Mutex
+	err = manifestParallel(paths, workers, func(p string) error {
+		digests, err := fileChecksums(fsys, p, checksums)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		m[p] = digests
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ManifestMismatch describes a file whose digest does not match the manifest
+type ManifestMismatch struct {
+	Path      string
+	Algorithm DigestAlgorithm
+	Expected  string
+	Actual    string
+}
+
+func (mm *ManifestMismatch) String() string {
+	return fmt.Sprintf("%s: %s mismatch (expected %s, got %s)", mm.Path, mm.Algorithm, mm.Expected, mm.Actual)
+}
+
+// ManifestReport is the result of VerifyManifest
+type ManifestReport struct {
+	// OK contains the files which match all digests of the manifest
+	OK []string
+	// Missing contains the files of the manifest not found in the tree
+	Missing []string
+	// Extra contains the files of the tree not listed in the manifest
+	Extra []string
+	// Mismatch contains the digests which differ from the manifest
+	Mismatch []*ManifestMismatch
+}
+
+// Valid reports whether the tree matches the manifest exactly
+func (r *ManifestReport) Valid() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Mismatch) == 0
+}
+
+// VerifyManifest checks the files below root within fsys against the manifest using the given number of workers.
+// If workers is less than 1, runtime.NumCPU() workers are used.
+// Missing, extra and mismatching files are reported in the result; the error is reserved for read failures.
+func VerifyManifest(fsys fs.FS, root string, m Manifest, workers int) (*ManifestReport, error) {
+	paths, err := manifestWalk(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	var report = &ManifestReport{}
+	for _, p := range paths {
+		if _, ok := m[p]; !ok {
+			report.Extra = append(report.Extra, p)
+		}
+	}
+	var lock sync.Mutex
+	err = manifestParallel(m.Paths(), workers, func(p string) error {
+		expected := m[p]
+		algs := make([]DigestAlgorithm, 0, len(expected))
+		for alg := range expected {
+			algs = append(algs, alg)
+		}
+		slices.Sort(algs)
+		digests, err := fileChecksums(fsys, p, algs)
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				report.Missing = append(report.Missing, p)
+				return nil
+			}
+			return err
+		}
+		var ok = true
+		for _, alg := range algs {
+			if !strings.EqualFold(digests[alg], expected[alg]) {
+				ok = false
+				report.Mismatch = append(report.Mismatch, &ManifestMismatch{
+					Path:      p,
+					Algorithm: alg,
+					Expected:  expected[alg],
+					Actual:    digests[alg],
+				})
+			}
+		}
+		if ok {
+			report.OK = append(report.OK, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(report.OK)
+	slices.Sort(report.Missing)
+	slices.SortFunc(report.Mismatch, func(a, b *ManifestMismatch) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Algorithm), string(b.Algorithm))
+	})
+	return report, nil
+}
+
+func manifestWalk(fsys fs.FS, root string) ([]string, error) {
+	if root == "" {
+		root = "."
+	}
+	var paths = []string{}
+	if err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "cannot walk '%s'", root)
+	}
+	return paths, nil
+}
+
+func manifestParallel(paths []string, workers int, fn func(p string) error) error {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	var jobs = make(chan string)
+	var errs []error
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				if err := fn(p); err != nil {
+					errLock.Lock()
+					errs = append(errs, err)
+					errLock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, p := range paths {
+		jobs <- p
+	}
+	close(jobs)
+	wg.Wait()
+	return errors.Combine(errs...)
+}
+
+func fileChecksums(fsys fs.FS, p string, checksums []DigestAlgorithm) (map[DigestAlgorithm]string, error) {
+	fp, err := fsys.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open '%s'", p)
+	}
+	defer fp.Close()
+	digests, err := Copy(checksums, fp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create checksums of '%s'", p)
+	}
+	return digests, nil
+}
@@ -0,0 +1,58 @@
+package checksum
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func TestManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"bagit.txt":          {Data: []byte("BagIt-Version: 1.0\n")},
+		"data/hello.txt":     {Data: []byte("Hello World!")},
+		"data/sub/empty.txt": {Data: []byte{}},
+	}
+	m, err := CreateManifest(fsys, "data", []DigestAlgorithm{DigestSHA256, DigestMD5}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["data/hello.txt"][DigestSHA256] != "7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069" {
+		t.Errorf("invalid sha256 for 'data/hello.txt': %s", m["data/hello.txt"][DigestSHA256])
+	}
+
+	for _, format := range []ManifestFormat{ManifestFormatGNU, ManifestFormatBSD, ManifestFormatBagIt} {
+		buf := &bytes.Buffer{}
+		if err := m.Write(buf, format, DigestSHA256); err != nil {
+			t.Fatalf("cannot write %s manifest: %v", format, err)
+		}
+		m2, err := ReadManifest(buf, format, DigestSHA256)
+		if err != nil {
+			t.Fatalf("cannot read %s manifest: %v", format, err)
+		}
+		for _, p := range m.Paths() {
+			if m2[p][DigestSHA256] != m[p][DigestSHA256] {
+				t.Errorf("%s manifest: invalid digest for '%s': %s != %s", format, p, m2[p][DigestSHA256], m[p][DigestSHA256])
+			}
+		}
+	}
+
+	fsys["data/hello.txt"] = &fstest.MapFile{Data: []byte("Hello World?")}
+	fsys["data/extra.txt"] = &fstest.MapFile{Data: []byte("extra")}
+	delete(fsys, "data/sub/empty.txt")
+	report, err := VerifyManifest(fsys, "data", m, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid() {
+		t.Error("modified tree reported as valid")
+	}
+	if len(report.Missing) != 1 || report.Missing[0] != "data/sub/empty.txt" {
+		t.Errorf("invalid missing files: %v", report.Missing)
+	}
+	if len(report.Extra) != 1 || report.Extra[0] != "data/extra.txt" {
+		t.Errorf("invalid extra files: %v", report.Extra)
+	}
+	if len(report.Mismatch) != 2 || report.Mismatch[0].Path != "data/hello.txt" {
+		t.Errorf("invalid mismatches: %v", report.Mismatch)
+	}
+}
@@ -1,8 +1,12 @@
 package checksum
 
 import (
+	"encoding"
+	"encoding/binary"
 	"hash"
 	"strconv"
+
+	"emperror.dev/errors"
 )
 
 type sizeHash struct {
@@ -35,3 +39,20 @@ func (s *sizeHash) Size() int {
 func (s *sizeHash) BlockSize() int {
 	return 1
 }
+
+func (s *sizeHash) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, s.size), nil
+}
+
+func (s *sizeHash) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return errors.Errorf("invalid size hash state length %d", len(data))
+	}
+	s.size = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*sizeHash)(nil)
+	_ encoding.BinaryUnmarshaler = (*sizeHash)(nil)
+)
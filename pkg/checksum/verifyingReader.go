@@ -0,0 +1,137 @@
+package checksum
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// ErrChecksumMismatch is returned by VerifyingReader if the data does not match the expected digests
+type ErrChecksumMismatch struct {
+	Expected map[DigestAlgorithm]string
+	Actual   map[DigestAlgorithm]string
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	var algs = make([]DigestAlgorithm, 0, len(e.Expected))
+	for alg := range e.Expected {
+		algs = append(algs, alg)
+	}
+	slices.Sort(algs)
+	var parts = []string{}
+	for _, alg := range algs {
+		if !strings.EqualFold(e.Expected[alg], e.Actual[alg]) {
+			parts = append(parts, fmt.Sprintf("%s: expected %s, got %s", alg, e.Expected[alg], e.Actual[alg]))
+		}
+	}
+	return fmt.Sprintf("checksum mismatch (%s)", strings.Join(parts, "; "))
+}
+
+// VerifyingReader passes the data of the underlying reader through and checks the expected
+// digests at the end. Instead of io.EOF the final Read returns an *ErrChecksumMismatch if
+// any digest differs. The expected DigestSize is the decimal number of bytes, all other digests
+// are hex encoded. GetChecksums returns the digests in the encoding of ChecksumWriter.
+type VerifyingReader struct {
+	reader   io.Reader
+	expected map[DigestAlgorithm]string
+	// expectedSize is the parsed DigestSize of expected
+	expectedSize int64
+	size         int64
+	sinks        map[DigestAlgorithm]hash.Hash
+	writer       io.Writer
+	result       error
+	finished     bool
+}
+
+func NewVerifyingReader(reader io.Reader, expected map[DigestAlgorithm]string) (*VerifyingReader, error) {
+	if len(expected) == 0 {
+		return nil, errors.New("no expected digests")
+	}
+	var vr = &VerifyingReader{
+		reader:   reader,
+		expected: expected,
+		sinks:    map[DigestAlgorithm]hash.Hash{},
+	}
+	if size, ok := expected[DigestSize]; ok {
+		var err error
+		if vr.expectedSize, err = strconv.ParseInt(size, 10, 64); err != nil || vr.expectedSize < 0 {
+			return nil, errors.Errorf("invalid expected size '%s'", size)
+		}
+	}
+	var writers = []io.Writer{}
+	for alg := range expected {
+		sink, err := GetHash(alg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot create hash for '%s'", alg)
+		}
+		vr.sinks[alg] = sink
+		writers = append(writers, sink)
+	}
+	vr.writer = io.MultiWriter(writers...)
+	return vr, nil
+}
+
+func (vr *VerifyingReader) Read(p []byte) (n int, err error) {
+	if vr.finished {
+		return 0, vr.result
+	}
+	n, err = vr.reader.Read(p)
+	if n > 0 {
+		// hash.Hash.Write never returns an error
+		vr.writer.Write(p[:n])
+		vr.size += int64(n)
+	}
+	if err == io.EOF {
+		vr.finished = true
+		vr.result = vr.verify()
+		return n, vr.result
+	}
+	return n, err
+}
+
+// GetChecksums returns the computed digests after the underlying reader reached EOF
+func (vr *VerifyingReader) GetChecksums() (map[DigestAlgorithm]string, error) {
+	if !vr.finished {
+		return nil, errors.New("verifying reader not finished")
+	}
+	return vr.actual(), nil
+}
+
+func (vr *VerifyingReader) actual() map[DigestAlgorithm]string {
+	var actual = map[DigestAlgorithm]string{}
+	for alg, sink := range vr.sinks {
+		actual[alg] = fmt.Sprintf("%x", sink.Sum(nil))
+	}
+	return actual
+}
+
+func (vr *VerifyingReader) verify() error {
+	actual := vr.actual()
+	var mismatch bool
+	for alg, digest := range vr.expected {
+		if alg == DigestSize {
+			// the size is compared as number and reported like the expected one
+			actual[alg] = strconv.FormatInt(vr.size, 10)
+			mismatch = mismatch || vr.size != vr.expectedSize
+			continue
+		}
+		mismatch = mismatch || !strings.EqualFold(digest, actual[alg])
+	}
+	if mismatch {
+		return &ErrChecksumMismatch{
+			Expected: vr.expected,
+			Actual:   actual,
+		}
+	}
+	return io.EOF
+}
+
+var (
+	_ io.Reader = (*VerifyingReader)(nil)
+	_ error     = (*ErrChecksumMismatch)(nil)
+)
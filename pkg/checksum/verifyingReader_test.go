@@ -0,0 +1,93 @@
+package checksum
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	var expected = map[DigestAlgorithm]string{
+		DigestSHA256: "7f83b1657ff1fc53b92dc18148a1d65dfc2d4b1fa3d677284addd200126d9069",
+		DigestMD5:    "ED076287532E86365E841E92BFC50D8C",
+		DigestSize:   "12",
+	}
+	vr, err := NewVerifyingReader(strings.NewReader("Hello World!"), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(vr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello World!" {
+		t.Errorf("invalid data: %s", data)
+	}
+
+	vr, err = NewVerifyingReader(strings.NewReader("Hello World?"), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(vr)
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if mismatch.Actual[DigestSize] != "12" || mismatch.Actual[DigestMD5] == mismatch.Expected[DigestMD5] {
+		t.Errorf("invalid mismatch: %v", mismatch)
+	}
+}
+
+func TestVerifyingReaderMatchesChecksumWriter(t *testing.T) {
+	var algs = []DigestAlgorithm{DigestSHA256, DigestMD5, DigestSize, DigestCRC32, DigestBlake3_256}
+	w, err := NewChecksumWriter(algs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	css, err := w.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the results of the writer are accepted as expected digests, the size is given in bytes
+	var expected = maps.Clone(css)
+	expected[DigestSize] = "12"
+	vr, err := NewVerifyingReader(strings.NewReader("Hello World!"), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(vr); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := vr.GetChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range algs {
+		if actual[alg] != css[alg] {
+			t.Errorf("different encoding for '%s': %s != %s", alg, actual[alg], css[alg])
+		}
+	}
+}
+
+func TestVerifyingReaderSize(t *testing.T) {
+	if _, err := NewVerifyingReader(strings.NewReader(""), map[DigestAlgorithm]string{DigestSize: "3132x"}); err == nil {
+		t.Error("invalid size accepted")
+	}
+	vr, err := NewVerifyingReader(strings.NewReader("Hello World!"), map[DigestAlgorithm]string{DigestSize: "13"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(vr)
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) || mismatch.Actual[DigestSize] != "12" {
+		t.Errorf("size mismatch not detected: %v", err)
+	}
+}
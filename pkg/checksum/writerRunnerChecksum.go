@@ -1,28 +1,59 @@
 package checksum
 
 import (
-	"emperror.dev/errors"
+	"encoding"
 	"fmt"
-	"github.com/je4/utils/v2/pkg/concurrentWriter"
+	"hash"
 	"io"
 	"sync"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/concurrentWriter"
 )
 
+// ErrStateNotSupported is returned if the hash function of an algorithm cannot serialize its state
+var ErrStateNotSupported = errors.New("state serialization not supported")
+
 type WriterRunnerChecksum struct {
 	alg       DigestAlgorithm
+	sink      hash.Hash
 	digest    string
 	errors    []error
 	errorLock sync.Mutex
+	// sinkLock protects sink, hashed and finished
+	sinkLock sync.Mutex
+	sinkCond *sync.Cond
+	hashed   uint64
+	finished bool
 }
 
 func NewWriterRunnerChecksum(alg DigestAlgorithm) (*WriterRunnerChecksum, error) {
 	if !HashExists(alg) {
 		return nil, errors.Errorf("unknown hash algorithm '%s'", alg)
 	}
+	sink, err := GetHash(alg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hash function %s", alg)
+	}
 	wrc := &WriterRunnerChecksum{
 		alg:       alg,
+		sink:      sink,
 		errorLock: sync.Mutex{},
 	}
+	wrc.sinkCond = sync.NewCond(&wrc.sinkLock)
+	return wrc, nil
+}
+
+// NewWriterRunnerChecksumFromState creates a runner which continues hashing from a state
+// created by MarshalBinary
+func NewWriterRunnerChecksumFromState(alg DigestAlgorithm, state []byte) (*WriterRunnerChecksum, error) {
+	wrc, err := NewWriterRunnerChecksum(alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := wrc.UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
 	return wrc, nil
 }
 
@@ -37,19 +68,35 @@ func (w *WriterRunnerChecksum) Do(reader io.Reader, done chan bool) {
 	defer func() {
 		done <- true
 	}()
+	defer func() {
+		w.sinkLock.Lock()
+		w.finished = true
+		w.sinkCond.Broadcast()
+		w.sinkLock.Unlock()
+	}()
 
-	sink, err := GetHash(w.alg)
-	if err != nil {
-		w.setError(errors.New(fmt.Sprintf("invalid hash function %s", w.alg)))
-		null := &NullWriter{}
-		io.Copy(null, reader)
-		return
+	// the data is hashed under the lock, so that a snapshot sees complete writes only
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			w.sinkLock.Lock()
+			w.sink.Write(buf[:n])
+			w.hashed += uint64(n)
+			w.sinkCond.Broadcast()
+			w.sinkLock.Unlock()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.setError(errors.Wrapf(err, "cannot create checkum %s", w.alg))
+			return
+		}
 	}
-	if _, err := io.Copy(sink, reader); err != nil {
-		w.setError(errors.Wrapf(err, "cannot create checkum %s", w.alg))
-		return
-	}
-	w.digest = fmt.Sprintf("%x", sink.Sum(nil))
+	w.sinkLock.Lock()
+	w.digest = fmt.Sprintf("%x", w.sink.Sum(nil))
+	w.sinkLock.Unlock()
 }
 
 func (w *WriterRunnerChecksum) GetName() string {
@@ -66,6 +113,67 @@ func (w *WriterRunnerChecksum) GetDigest() (string, error) {
 	return w.digest, errors.Combine(w.errors...)
 }
 
+func (w *WriterRunnerChecksum) GetError() error {
+	w.errorLock.Lock()
+	defer w.errorLock.Unlock()
+	return errors.Combine(w.errors...)
+}
+
+// MarshalBinary returns the internal state of the hash function with the data consumed so far
+func (w *WriterRunnerChecksum) MarshalBinary() ([]byte, error) {
+	w.sinkLock.Lock()
+	defer w.sinkLock.Unlock()
+	return w.marshalSink()
+}
+
+// Snapshot waits until the runner has hashed size bytes and returns the state of the hash function
+func (w *WriterRunnerChecksum) Snapshot(size uint64) ([]byte, error) {
+	if _, ok := w.sink.(encoding.BinaryMarshaler); !ok {
+		return nil, errors.Wrapf(ErrStateNotSupported, "hash function %s", w.alg)
+	}
+	w.sinkLock.Lock()
+	defer w.sinkLock.Unlock()
+	for w.hashed < size && !w.finished {
+		w.sinkCond.Wait()
+	}
+	if w.hashed < size {
+		if err := w.GetError(); err != nil {
+			return nil, errors.Wrapf(err, "cannot get state of %s", w.alg)
+		}
+		return nil, errors.Errorf("%s finished after %d of %d bytes", w.alg, w.hashed, size)
+	}
+	return w.marshalSink()
+}
+
+// marshalSink serializes the hash function. The caller holds sinkLock
+func (w *WriterRunnerChecksum) marshalSink() ([]byte, error) {
+	m, ok := w.sink.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, errors.Wrapf(ErrStateNotSupported, "hash function %s", w.alg)
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot marshal state of %s", w.alg)
+	}
+	return state, nil
+}
+
+// UnmarshalBinary restores the internal state of the hash function.
+// It must be called before the runner is started.
+func (w *WriterRunnerChecksum) UnmarshalBinary(state []byte) error {
+	u, ok := w.sink.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.Wrapf(ErrStateNotSupported, "hash function %s", w.alg)
+	}
+	if err := u.UnmarshalBinary(state); err != nil {
+		return errors.Wrapf(err, "cannot unmarshal state of %s", w.alg)
+	}
+	return nil
+}
+
 var (
-	_ concurrentWriter.WriterRunner = (*WriterRunnerChecksum)(nil)
+	_ concurrentWriter.WriterRunner      = (*WriterRunnerChecksum)(nil)
+	_ concurrentWriter.WriterRunnerError = (*WriterRunnerChecksum)(nil)
+	_ encoding.BinaryMarshaler           = (*WriterRunnerChecksum)(nil)
+	_ encoding.BinaryUnmarshaler         = (*WriterRunnerChecksum)(nil)
 )
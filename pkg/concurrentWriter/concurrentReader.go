@@ -0,0 +1,122 @@
+package concurrentWriter
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// ctxReader stops reading as soon as the context is done
+type ctxReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.reader.Read(p)
+}
+
+// ConcurrentReader is the counterpart of ConcurrentWriter for seekable sources.
+// Every runner reads the data independently with its own io.SectionReader,
+// so fast runners are not slowed down by slow ones.
+type ConcurrentReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	errors   []error
+	dataLock sync.Mutex
+	wg       sync.WaitGroup
+	runners  []WriterRunner
+	waited   bool
+}
+
+// NewConcurrentReader starts all runners on the first size bytes of readerAt.
+// If ctx is canceled or a runner fails, the readers of all other runners fail.
+func NewConcurrentReader(ctx context.Context, readerAt io.ReaderAt, size int64, runners []WriterRunner) *ConcurrentReader {
+	c := &ConcurrentReader{
+		errors:   []error{},
+		dataLock: sync.Mutex{},
+		runners:  runners,
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	for _, runner := range runners {
+		c.wg.Add(1)
+		go c.run(runner, &ctxReader{ctx: c.ctx, reader: io.NewSectionReader(readerAt, 0, size)})
+	}
+	return c
+}
+
+func (c *ConcurrentReader) run(runner WriterRunner, reader io.Reader) {
+	defer c.wg.Done()
+	done := make(chan bool, 1)
+	go runner.Do(reader, done)
+	<-done
+	if re, ok := runner.(WriterRunnerError); ok {
+		if err := re.GetError(); err != nil {
+			c.setError(errors.Wrapf(err, "runner '%s' failed", runner.GetName()))
+			c.cancel()
+		}
+	}
+}
+
+func (c *ConcurrentReader) setError(err error) {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	c.errors = append(c.errors, err)
+}
+
+// Wait waits until all runners are finished and returns their combined errors
+func (c *ConcurrentReader) Wait() error {
+	return c.WaitTimeout(0)
+}
+
+// WaitTimeout waits at most timeout for all runners to finish.
+// A timeout of 0 waits until all runners are finished or the context is done.
+func (c *ConcurrentReader) WaitTimeout(timeout time.Duration) error {
+	c.dataLock.Lock()
+	if c.waited {
+		c.dataLock.Unlock()
+		return errors.New("reader already waited for")
+	}
+	c.waited = true
+	c.dataLock.Unlock()
+
+	finished := make(chan bool)
+	go func() {
+		c.wg.Wait()
+		close(finished)
+	}()
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	select {
+	case <-finished:
+	case <-timeoutChan:
+		c.setError(ErrCloseTimeout)
+	}
+	// runner errors cancel the context too, so only report the context if nothing else failed
+	if err := c.ctx.Err(); err != nil && len(c.GetErrors()) == 0 {
+		c.setError(errors.Wrap(err, "context done"))
+	}
+	c.cancel()
+	return errors.Combine(c.GetErrors()...)
+}
+
+// GetErrors returns the errors collected so far
+func (c *ConcurrentReader) GetErrors() []error {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	return append([]error{}, c.errors...)
+}
+
+func (c *ConcurrentReader) GetRunners() []WriterRunner {
+	return c.runners
+}
@@ -0,0 +1,32 @@
+package concurrentWriter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConcurrentReader(t *testing.T) {
+	data := strings.Repeat("Hello World!", 1000)
+	buf1 := &bytes.Buffer{}
+	buf2 := &bytes.Buffer{}
+	cr := NewConcurrentReader(context.Background(), strings.NewReader(data), int64(len(data)), []WriterRunner{
+		NewGenericCopyRunner(buf1, "copy1"),
+		NewGenericCopyRunner(buf2, "copy2"),
+	})
+	if err := cr.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if buf1.String() != data || buf2.String() != data {
+		t.Error("invalid data copied")
+	}
+
+	cr = NewConcurrentReader(context.Background(), strings.NewReader(data), int64(len(data)), []WriterRunner{
+		NewGenericCopyRunner(&failingWriter{after: 100}, "failing"),
+		NewGenericCopyRunner(&bytes.Buffer{}, "copy"),
+	})
+	if err := cr.Wait(); err == nil {
+		t.Error("runner error not returned")
+	}
+}
@@ -1,9 +1,15 @@
 package concurrentWriter
 
 import (
-	"emperror.dev/errors"
+	"context"
 	"io"
+	"maps"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"emperror.dev/errors"
 )
 
 type WriterRunner interface {
@@ -11,27 +17,64 @@ type WriterRunner interface {
 	GetName() string
 }
 
+// WriterRunnerError is an optional extension of WriterRunner.
+// The error of runners implementing it is checked after Do finished and aggregated by ConcurrentWriter.
+type WriterRunnerError interface {
+	GetError() error
+}
+
+// ErrCloseTimeout is returned by CloseTimeout if the runners did not finish in time
+var ErrCloseTimeout = errors.New("timeout waiting for runners")
+
+// CloseGracePeriod is the time CloseTimeout waits for the runners to stop after
+// a timeout or a done context closed their pipes
+var CloseGracePeriod = time.Second
+
 type pipe struct {
-	reader *io.PipeReader
-	writer *io.PipeWriter
+	reader   *io.PipeReader
+	writer   *io.PipeWriter
+	runner   WriterRunner
+	stats    *pipeStats
+	detached atomic.Bool
+	finished chan bool
+}
+
+func newPipe(runner WriterRunner) *pipe {
+	rw := &pipe{
+		runner:   runner,
+		stats:    &pipeStats{},
+		finished: make(chan bool),
+	}
+	rw.reader, rw.writer = io.Pipe()
+	return rw
 }
 
 type ConcurrentWriter struct {
+	ctx       context.Context
 	errors    []error
-	rwsWriter io.Writer
-	rws       map[string]pipe
+	firstErr  error
+	rws       map[string]*pipe
 	dataLock  sync.Mutex
-	done      chan bool
+	writeLock sync.Mutex
+	offset    int64
+	wg        sync.WaitGroup
+	end       chan bool
 	open      bool
 	runners   []WriterRunner
+	// stopWatch unregisters the cancellation of the pipes on ctx.Done
+	stopWatch func() bool
 }
 
-func NewConcurrentWriter(runners []WriterRunner, writer ...io.Writer) *ConcurrentWriter {
+// NewConcurrentWriter starts all runners and an optional copy to the writers.
+// If ctx is canceled or a runner fails, all pipes are closed with the error and
+// subsequent writes fail immediately.
+func NewConcurrentWriter(ctx context.Context, runners []WriterRunner, writer ...io.Writer) *ConcurrentWriter {
 	c := &ConcurrentWriter{
+		ctx:      ctx,
 		errors:   []error{},
-		rws:      map[string]pipe{},
+		rws:      map[string]*pipe{},
 		dataLock: sync.Mutex{},
-		done:     make(chan bool),
+		end:      make(chan bool),
 		open:     true,
 		runners:  runners,
 	}
@@ -43,13 +86,7 @@ func (c *ConcurrentWriter) start(writers ...io.Writer) {
 
 	// create the map of all ChecksumCopy-pipes and start async process
 	for _, runner := range c.runners {
-		rw := pipe{}
-		rw.reader, rw.writer = io.Pipe()
-		var r = runner
-		go func(reader io.Reader, done chan bool) {
-			r.Do(reader, done)
-		}(rw.reader, c.done)
-		c.rws[runner.GetName()] = rw
+		c.rws[runner.GetName()] = newPipe(runner)
 	}
 
 	if len(writers) > 0 {
@@ -60,27 +97,64 @@ func (c *ConcurrentWriter) start(writers ...io.Writer) {
 		} else {
 			dst = writers[0]
 		}
-
 		// target pipe
-		rw := pipe{}
-		rw.reader, rw.writer = io.Pipe()
-		go func(writer io.Writer, reader io.Reader, done chan bool) {
-			defer func() { done <- true }()
-			_, err := io.Copy(writer, reader)
-			if err != nil {
-				c.setError(errors.Wrap(err, "cannot copy to target destination"))
-				return
-			}
-		}(dst, rw.reader, c.done)
-		c.rws["_"] = rw
+		c.rws["_"] = newPipe(NewGenericCopyRunner(dst, "_"))
 	}
-	// create list of writer
-	allWriters := []io.Writer{}
-	for _, rw := range c.rws {
-		allWriters = append(allWriters, rw.writer)
+
+	for name, rw := range c.rws {
+		c.wg.Add(1)
+		rw.stats.start = time.Now()
+		go c.run(name, rw)
+	}
+
+	// cancel everything if context is done. AfterFunc does not need a goroutine, so nothing
+	// leaks if the context is never canceled
+	c.stopWatch = context.AfterFunc(c.ctx, func() {
+		c.fail(errors.Wrap(c.ctx.Err(), "context done"))
+	})
+}
+
+// run executes the runner and closes all pipes if it fails or stops reading before the end of data
+func (c *ConcurrentWriter) run(name string, rw *pipe) {
+	defer c.wg.Done()
+	defer close(rw.finished)
+	done := make(chan bool, 1)
+	go rw.runner.Do(&countingReader{reader: rw.reader, stats: rw.stats}, done)
+	<-done
+	rw.stats.end.Store(time.Now().UnixNano())
+	var err error
+	if re, ok := rw.runner.(WriterRunnerError); ok {
+		err = re.GetError()
+	}
+	if rw.detached.Load() {
+		// errors of detached runners are reported by RemoveRunner
+		return
 	}
+	if err != nil {
+		c.fail(errors.Wrapf(err, "runner '%s' failed", name))
+		return
+	}
+	c.dataLock.Lock()
+	open := c.open
+	c.dataLock.Unlock()
+	if open {
+		c.fail(errors.Errorf("runner '%s' finished before end of data", name))
+	}
+}
 
-	c.rwsWriter = io.MultiWriter(allWriters...)
+// fail stores the error and closes all pipes with the first one
+func (c *ConcurrentWriter) fail(err error) {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	c.errors = append(c.errors, err)
+	if c.firstErr != nil {
+		return
+	}
+	c.firstErr = err
+	for _, rw := range c.rws {
+		rw.writer.CloseWithError(err)
+		rw.reader.CloseWithError(err)
+	}
 }
 
 func (c *ConcurrentWriter) setError(err error) {
@@ -89,32 +163,102 @@ func (c *ConcurrentWriter) setError(err error) {
 	c.errors = append(c.errors, err)
 }
 
+// GetError returns the first error of the runners or the context
+func (c *ConcurrentWriter) GetError() error {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	return c.firstErr
+}
+
 func (c *ConcurrentWriter) Write(p []byte) (n int, err error) {
-	return c.rwsWriter.Write(p)
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.GetError(); err != nil {
+		return 0, err
+	}
+	for name, rw := range c.rws {
+		start := time.Now()
+		_, err := rw.writer.Write(p)
+		rw.stats.blocked.Add(int64(time.Since(start)))
+		if err != nil {
+			if firstErr := c.GetError(); firstErr != nil {
+				return 0, firstErr
+			}
+			return 0, errors.Wrapf(err, "cannot write to pipe '%s'", name)
+		}
+	}
+	c.offset += int64(len(p))
+	return len(p), nil
 }
 
-// Close closes all pipes. It does not close the underlying writer
+// Close closes all pipes and waits for all runners. It does not close the underlying writer
 func (c *ConcurrentWriter) Close() error {
+	return c.CloseTimeout(0)
+}
+
+// CloseTimeout closes all pipes and waits at most timeout for all runners to finish.
+// A timeout of 0 waits until all runners are finished or the context is done.
+func (c *ConcurrentWriter) CloseTimeout(timeout time.Duration) error {
+	c.dataLock.Lock()
 	if !c.open {
+		c.dataLock.Unlock()
 		return errors.New("writer already closed")
 	}
-	defer func() { c.open = false }()
-	for key, rw := range c.rws {
+	c.open = false
+	rws := maps.Clone(c.rws)
+	c.dataLock.Unlock()
+
+	for key, rw := range rws {
 		if err := rw.writer.Close(); err != nil {
-			c.errors = append(c.errors, errors.Wrapf(err, "error closing pipe '%s'", key))
+			c.setError(errors.Wrapf(err, "error closing pipe '%s'", key))
 		}
 	}
 
-	// wait until all checksums and destination done
-	for cnt := 0; cnt < len(c.rws); cnt++ {
-		<-c.done
+	// wait until all runners and destination are done
+	finished := make(chan bool)
+	go func() {
+		c.wg.Wait()
+		close(finished)
+	}()
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
 	}
+	select {
+	case <-finished:
+	case <-timeoutChan:
+		c.fail(ErrCloseTimeout)
+		c.waitGrace(finished)
+	case <-c.ctx.Done():
+		if c.GetError() == nil {
+			c.fail(errors.Wrap(c.ctx.Err(), "context done"))
+		}
+		c.waitGrace(finished)
+	}
+	c.stopWatch()
+	close(c.end)
 
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
 	return errors.Combine(c.errors...)
 }
 
+// waitGrace gives the runners CloseGracePeriod to stop after their pipes are closed, so that their errors are collected
+func (c *ConcurrentWriter) waitGrace(finished <-chan bool) {
+	timer := time.NewTimer(CloseGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-finished:
+	case <-timer.C:
+	}
+}
+
 func (c *ConcurrentWriter) GetRunners() []WriterRunner {
-	return c.runners
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	return slices.Clone(c.runners)
 }
 
 var (
@@ -0,0 +1,194 @@
+package concurrentWriter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+type failingWriter struct {
+	after int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if fw.after -= len(p); fw.after < 0 {
+		return 0, errors.New("write failed")
+	}
+	return len(p), nil
+}
+
+type blockingRunner struct{}
+
+func (br *blockingRunner) Do(reader io.Reader, done chan bool) {
+	time.Sleep(time.Second)
+	done <- true
+}
+
+func (br *blockingRunner) GetName() string {
+	return "blocking"
+}
+
+func TestConcurrentWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	runnerBuf := &bytes.Buffer{}
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{NewGenericCopyRunner(runnerBuf, "copy")}, buf)
+	if _, err := cw.Write([]byte("Hello World!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello World!" || runnerBuf.String() != "Hello World!" {
+		t.Errorf("invalid result '%s' / '%s'", buf.String(), runnerBuf.String())
+	}
+}
+
+func TestConcurrentWriterRunnerError(t *testing.T) {
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{NewGenericCopyRunner(&failingWriter{after: 5}, "failing")})
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = cw.Write([]byte("Hello World!"))
+	}
+	if err == nil {
+		t.Fatal("runner error not returned from Write")
+	}
+	if err := cw.Close(); err == nil {
+		t.Error("runner error not returned from Close")
+	}
+}
+
+func TestConcurrentWriterContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cw := NewConcurrentWriter(ctx, []WriterRunner{NewGenericCopyRunner(io.Discard, "discard")})
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := cw.Write([]byte("Hello World!")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := cw.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestConcurrentWriterCloseTimeout(t *testing.T) {
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{&blockingRunner{}})
+	if err := cw.CloseTimeout(10 * time.Millisecond); !errors.Is(err, ErrCloseTimeout) {
+		t.Errorf("expected ErrCloseTimeout, got %v", err)
+	}
+}
+
+type slowFailingRunner struct {
+	err error
+}
+
+func (sr *slowFailingRunner) Do(reader io.Reader, done chan bool) {
+	io.Copy(io.Discard, reader)
+	time.Sleep(50 * time.Millisecond)
+	sr.err = errors.New("slow runner failed")
+	done <- true
+}
+
+func (sr *slowFailingRunner) GetName() string {
+	return "slow"
+}
+
+func (sr *slowFailingRunner) GetError() error {
+	return sr.err
+}
+
+func TestConcurrentWriterCloseTimeoutGrace(t *testing.T) {
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{&slowFailingRunner{}})
+	err := cw.CloseTimeout(10 * time.Millisecond)
+	if !errors.Is(err, ErrCloseTimeout) {
+		t.Errorf("expected ErrCloseTimeout, got %v", err)
+	}
+	// the runner stops within the grace period, so its error is collected
+	if err == nil || !strings.Contains(err.Error(), "slow runner failed") {
+		t.Errorf("runner error missing in %v", err)
+	}
+}
+
+func TestConcurrentWriterStats(t *testing.T) {
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{NewGenericCopyRunner(io.Discard, "discard")}, io.Discard)
+	var callbackStats map[string]RunnerStats
+	var callbackDone = make(chan bool)
+	cw.StatsCallback(time.Hour, func(stats map[string]RunnerStats) {
+		callbackStats = stats
+		if stats["discard"].Finished {
+			close(callbackDone)
+		}
+	})
+	for i := 0; i < 10; i++ {
+		if _, err := cw.Write([]byte("Hello World!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stats := cw.Stats()
+	for _, name := range []string{"discard", "_"} {
+		if stats[name].Bytes != 120 || !stats[name].Finished {
+			t.Errorf("invalid stats for '%s': %+v", name, stats[name])
+		}
+	}
+	<-callbackDone
+	if callbackStats["discard"].Bytes != 120 {
+		t.Errorf("invalid callback stats: %+v", callbackStats["discard"])
+	}
+}
+
+type offsetRunner struct {
+	*GenericCopyRunner
+	offset int64
+}
+
+func (or *offsetRunner) SetOffset(offset int64) {
+	or.offset = offset
+}
+
+func TestConcurrentWriterAddRemoveRunner(t *testing.T) {
+	first := &bytes.Buffer{}
+	cw := NewConcurrentWriter(context.Background(), []WriterRunner{NewGenericCopyRunner(first, "first")})
+	if _, err := cw.Write([]byte("Hello ")); err != nil {
+		t.Fatal(err)
+	}
+	second := &offsetRunner{GenericCopyRunner: NewGenericCopyRunner(&bytes.Buffer{}, "second")}
+	offset, err := cw.AddRunner(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 6 || second.offset != 6 {
+		t.Errorf("invalid offset %d/%d", offset, second.offset)
+	}
+	if _, err := cw.AddRunner(NewGenericCopyRunner(io.Discard, "second")); err == nil {
+		t.Error("duplicate runner not rejected")
+	}
+	if _, err := cw.Write([]byte("World!")); err != nil {
+		t.Fatal(err)
+	}
+	offset, err = cw.RemoveRunner("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 12 || first.String() != "Hello World!" {
+		t.Errorf("invalid result after remove: %d '%s'", offset, first.String())
+	}
+	if _, err := cw.Write([]byte(" Bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if result := second.writer.(*bytes.Buffer).String(); result != "World! Bye" {
+		t.Errorf("invalid result of added runner: '%s'", result)
+	}
+	if len(cw.GetRunners()) != 1 {
+		t.Errorf("invalid number of runners %d", len(cw.GetRunners()))
+	}
+}
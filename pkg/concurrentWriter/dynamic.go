@@ -0,0 +1,76 @@
+package concurrentWriter
+
+import (
+	"slices"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// WriterRunnerOffset is an optional extension of WriterRunner.
+// Runners attached with AddRunner get the stream offset of their first byte before they are started.
+type WriterRunnerOffset interface {
+	SetOffset(offset int64)
+}
+
+// AddRunner attaches a runner to the running stream. The runner receives all data written after
+// the returned offset. It is safe to call AddRunner concurrently with Write.
+func (c *ConcurrentWriter) AddRunner(runner WriterRunner) (int64, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	if !c.open {
+		return 0, errors.New("writer already closed")
+	}
+	if c.firstErr != nil {
+		return 0, errors.Wrap(c.firstErr, "writer failed")
+	}
+	name := runner.GetName()
+	if _, ok := c.rws[name]; ok {
+		return 0, errors.Errorf("runner '%s' already exists", name)
+	}
+	if ro, ok := runner.(WriterRunnerOffset); ok {
+		ro.SetOffset(c.offset)
+	}
+	rw := newPipe(runner)
+	c.rws[name] = rw
+	c.runners = append(c.runners, runner)
+	c.wg.Add(1)
+	rw.stats.start = time.Now()
+	go c.run(name, rw)
+	return c.offset, nil
+}
+
+// RemoveRunner detaches a runner from the running stream. The runner gets the end of data,
+// RemoveRunner waits until it is finished and returns the stream offset after its last byte
+// together with the error of the runner. It is safe to call RemoveRunner concurrently with Write.
+func (c *ConcurrentWriter) RemoveRunner(name string) (int64, error) {
+	c.writeLock.Lock()
+	c.dataLock.Lock()
+	rw, ok := c.rws[name]
+	if !ok || name == "_" {
+		c.dataLock.Unlock()
+		c.writeLock.Unlock()
+		return 0, errors.Errorf("runner '%s' not found", name)
+	}
+	offset := c.offset
+	rw.detached.Store(true)
+	delete(c.rws, name)
+	c.runners = slices.DeleteFunc(c.runners, func(r WriterRunner) bool {
+		return r.GetName() == name
+	})
+	c.dataLock.Unlock()
+	c.writeLock.Unlock()
+
+	if err := rw.writer.Close(); err != nil {
+		return offset, errors.Wrapf(err, "error closing pipe '%s'", name)
+	}
+	<-rw.finished
+	if re, ok := rw.runner.(WriterRunnerError); ok {
+		if err := re.GetError(); err != nil {
+			return offset, errors.Wrapf(err, "runner '%s' failed", name)
+		}
+	}
+	return offset, nil
+}
@@ -36,3 +36,8 @@ func (w *GenericCopyRunner) GetError() error {
 func (w *GenericCopyRunner) GetName() string {
 	return w.name
 }
+
+var (
+	_ WriterRunner      = (*GenericCopyRunner)(nil)
+	_ WriterRunnerError = (*GenericCopyRunner)(nil)
+)
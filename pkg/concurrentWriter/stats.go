@@ -0,0 +1,90 @@
+package concurrentWriter
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RunnerStats contains the statistics of one runner of a ConcurrentWriter
+type RunnerStats struct {
+	Name string
+	// Bytes is the number of bytes consumed by the runner
+	Bytes int64
+	// Duration is the wall time of the runner up to now or until it finished
+	Duration time.Duration
+	// Blocked is the time the producer spent blocked writing into the pipe of the runner
+	Blocked time.Duration
+	// Finished is true if the runner has finished
+	Finished bool
+}
+
+// Throughput returns the consumed bytes per second
+func (rs RunnerStats) Throughput() float64 {
+	if rs.Duration <= 0 {
+		return 0
+	}
+	return float64(rs.Bytes) / rs.Duration.Seconds()
+}
+
+type pipeStats struct {
+	bytes   atomic.Int64
+	blocked atomic.Int64
+	start   time.Time
+	end     atomic.Int64
+}
+
+func (ps *pipeStats) get(name string) RunnerStats {
+	rs := RunnerStats{
+		Name:    name,
+		Bytes:   ps.bytes.Load(),
+		Blocked: time.Duration(ps.blocked.Load()),
+	}
+	if end := ps.end.Load(); end != 0 {
+		rs.Finished = true
+		rs.Duration = time.Unix(0, end).Sub(ps.start)
+	} else {
+		rs.Duration = time.Since(ps.start)
+	}
+	return rs
+}
+
+// countingReader counts the bytes consumed by a runner
+type countingReader struct {
+	reader io.Reader
+	stats  *pipeStats
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.stats.bytes.Add(int64(n))
+	return n, err
+}
+
+// Stats returns the statistics of all runners. The target writers are reported as runner "_"
+func (c *ConcurrentWriter) Stats() map[string]RunnerStats {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	var result = map[string]RunnerStats{}
+	for name, rw := range c.rws {
+		result[name] = rw.stats.get(name)
+	}
+	return result
+}
+
+// StatsCallback calls callback with the current statistics every interval until the writer is closed
+func (c *ConcurrentWriter) StatsCallback(interval time.Duration, callback func(stats map[string]RunnerStats)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.end:
+				callback(c.Stats())
+				return
+			case <-ticker.C:
+				callback(c.Stats())
+			}
+		}
+	}()
+}
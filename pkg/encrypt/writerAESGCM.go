@@ -1,6 +1,7 @@
 package encrypt
 
 import (
+	"context"
 	"emperror.dev/errors"
 	"encoding/base64"
 	"encoding/json"
@@ -65,19 +66,20 @@ func NewEncryptWriterAESGCM(dest io.Writer, aad []byte, keyTemplate *tink_go_pro
 	}
 
 	runner := concurrentWriter.NewGenericCopyRunner(c.encWriter, "aesgcm")
-	c.ConcurrentWriter = concurrentWriter.NewConcurrentWriter([]concurrentWriter.WriterRunner{runner}, writer...)
+	c.ConcurrentWriter = concurrentWriter.NewConcurrentWriter(context.Background(), []concurrentWriter.WriterRunner{runner}, writer...)
 
 	return c, nil
 }
 
 func (c *WriterAESGCM) Close() error {
 	var errs = []error{}
-	if err := c.encWriter.Close(); err != nil {
-		errs = append(errs, errors.Wrap(err, "cannot close encrypting writer"))
-	}
+	// the runner has to finish copying before the encrypting writer can be closed
 	if err := c.ConcurrentWriter.Close(); err != nil {
 		errs = append(errs, errors.Wrap(err, "cannot close concurrent writer"))
 	}
+	if err := c.encWriter.Close(); err != nil {
+		errs = append(errs, errors.Wrap(err, "cannot close encrypting writer"))
+	}
 	return errors.Combine(errs...)
 }
 
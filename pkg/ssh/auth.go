@@ -0,0 +1,284 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"os"
+
+	"github.com/je4/utils/v2/pkg/config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// PassphraseFunc returns the passphrase of the encrypted private key file keyFile
+type PassphraseFunc func(keyFile string) ([]byte, error)
+
+// EnvStringPassphrase returns a PassphraseFunc for a passphrase from the configuration.
+// Environment variables like %%SSH_PASSPHRASE%% are replaced
+func EnvStringPassphrase(passphrase config.EnvString) PassphraseFunc {
+	return func(keyFile string) ([]byte, error) {
+		var resolved config.EnvString
+		if err := resolved.UnmarshalText([]byte(passphrase)); err != nil {
+			return nil, errors.Wrapf(err, "cannot resolve passphrase of %s", keyFile)
+		}
+		if resolved == "" {
+			return nil, errors.Errorf("empty passphrase for %s", keyFile)
+		}
+		return []byte(resolved), nil
+	}
+}
+
+// AuthBuilder collects the authentication methods for NewSFTP and NewSSHTunnel.
+// Errors are reported by Build, problems which do not prevent authentication by Warnings.
+// Close releases the connection of WithAgent after the clients using the builder are closed
+type AuthBuilder struct {
+	signers             []ssh.Signer
+	password            string
+	agent               agent.ExtendedAgent
+	agentConn           io.Closer
+	keyboardInteractive ssh.KeyboardInteractiveChallenge
+	warnings            []string
+	err                 error
+}
+
+func NewAuthBuilder() *AuthBuilder {
+	return &AuthBuilder{}
+}
+
+func (ab *AuthBuilder) setError(err error) *AuthBuilder {
+	if ab.err == nil {
+		ab.err = err
+	}
+	return ab
+}
+
+// readPrivateKey parses keyFile. Encrypted keys are decrypted with the passphrase of passphrase
+func readPrivateKey(keyFile string, passphrase PassphraseFunc) (ssh.Signer, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read private key file %s", keyFile)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == nil {
+			return nil, errors.Errorf("private key file %s is encrypted, but no passphrase is given", keyFile)
+		}
+		pass, err := passphrase(keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get passphrase of %s", keyFile)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, pass)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decrypt private key %s", keyFile)
+		}
+		return signer, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse private key %s", keyFile)
+	}
+	return signer, nil
+}
+
+// readCertificate parses an OpenSSH user certificate
+func readCertificate(certFile string) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read certificate %s", certFile)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse certificate %s", certFile)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("%s is not a certificate", certFile)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.Errorf("%s is not a user certificate", certFile)
+	}
+	return cert, nil
+}
+
+// newCertSigner combines the user certificate certFile with signer
+func newCertSigner(signer ssh.Signer, keyFile, certFile string) (ssh.Signer, error) {
+	cert, err := readCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "certificate %s does not match %s", certFile, keyFile)
+	}
+	return certSigner, nil
+}
+
+// WithPrivateKeyFile adds a private key. If a certificate keyFile-cert.pub exists, it is used as well.
+// A file which is not a user certificate of the key is reported by Warnings and the plain key is used
+func (ab *AuthBuilder) WithPrivateKeyFile(keyFile string, passphrase PassphraseFunc) *AuthBuilder {
+	signer, err := readPrivateKey(keyFile, passphrase)
+	if err != nil {
+		return ab.setError(err)
+	}
+	certFile := keyFile + "-cert.pub"
+	if _, err := os.Stat(certFile); err == nil {
+		certSigner, err := newCertSigner(signer, keyFile, certFile)
+		if err == nil {
+			ab.signers = append(ab.signers, certSigner)
+		} else {
+			ab.warnings = append(ab.warnings, errors.Wrap(err, "certificate ignored").Error())
+		}
+	}
+	ab.signers = append(ab.signers, signer)
+	return ab
+}
+
+// WithCertificateFile adds the OpenSSH user certificate certFile of the private key keyFile.
+// The plain key is tried after the certificate
+func (ab *AuthBuilder) WithCertificateFile(keyFile, certFile string, passphrase PassphraseFunc) *AuthBuilder {
+	signer, err := readPrivateKey(keyFile, passphrase)
+	if err != nil {
+		return ab.setError(err)
+	}
+	certSigner, err := newCertSigner(signer, keyFile, certFile)
+	if err != nil {
+		return ab.setError(err)
+	}
+	ab.signers = append(ab.signers, certSigner, signer)
+	return ab
+}
+
+// WithSigner adds a signer, e.g. from a hardware token
+func (ab *AuthBuilder) WithSigner(signer ssh.Signer) *AuthBuilder {
+	ab.signers = append(ab.signers, signer)
+	return ab
+}
+
+// WithPassword adds password authentication
+func (ab *AuthBuilder) WithPassword(password string) *AuthBuilder {
+	ab.password = password
+	return ab
+}
+
+// WithAgent uses the keys of the ssh-agent listening on socket. With an empty socket SSH_AUTH_SOCK is used
+func (ab *AuthBuilder) WithAgent(socket string) *AuthBuilder {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return ab.setError(errors.New("no ssh-agent: SSH_AUTH_SOCK is not set"))
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return ab.setError(errors.Wrapf(err, "cannot connect to ssh-agent %s", socket))
+	}
+	if ab.agentConn != nil {
+		ab.agentConn.Close()
+	}
+	ab.agentConn = conn
+	return ab.WithAgentClient(agent.NewClient(conn))
+}
+
+// WithAgentClient uses the keys of an agent
+func (ab *AuthBuilder) WithAgentClient(agentClient agent.ExtendedAgent) *AuthBuilder {
+	ab.agent = agentClient
+	return ab
+}
+
+// WithKeyboardInteractive adds keyboard-interactive authentication
+func (ab *AuthBuilder) WithKeyboardInteractive(challenge ssh.KeyboardInteractiveChallenge) *AuthBuilder {
+	ab.keyboardInteractive = challenge
+	return ab
+}
+
+// merge adds the methods of other
+func (ab *AuthBuilder) merge(other *AuthBuilder) *AuthBuilder {
+	if other.err != nil {
+		ab.setError(other.err)
+	}
+	ab.signers = append(ab.signers, other.signers...)
+	ab.warnings = append(ab.warnings, other.warnings...)
+	if other.password != "" {
+		ab.password = other.password
+	}
+	if other.agent != nil {
+		ab.agent = other.agent
+	}
+	if other.keyboardInteractive != nil {
+		ab.keyboardInteractive = other.keyboardInteractive
+	}
+	return ab
+}
+
+// Warnings returns the problems which have been worked around, e.g. an ignored certificate
+func (ab *AuthBuilder) Warnings() []string {
+	return ab.warnings
+}
+
+// Close closes the connection to the ssh-agent of WithAgent. Clients using the builder cannot authenticate with
+// the agent anymore
+func (ab *AuthBuilder) Close() error {
+	if ab.agentConn == nil {
+		return nil
+	}
+	err := ab.agentConn.Close()
+	ab.agentConn = nil
+	return errors.Wrap(err, "cannot close ssh-agent connection")
+}
+
+// Build returns the authentication methods and the identity for the connection pool.
+// ssh tries every method only once, so all keys are combined into one public key method
+func (ab *AuthBuilder) Build() ([]ssh.AuthMethod, string, error) {
+	if ab.err != nil {
+		return nil, "", ab.err
+	}
+	var methods []ssh.AuthMethod
+	var publicKeys []ssh.PublicKey
+	for _, signer := range ab.signers {
+		publicKeys = append(publicKeys, signer.PublicKey())
+	}
+	if ab.agent != nil {
+		keys, err := ab.agent.List()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot list keys of ssh-agent")
+		}
+		for _, key := range keys {
+			publicKeys = append(publicKeys, key)
+		}
+	}
+	if len(ab.signers) > 0 || ab.agent != nil {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			signers := ab.signers
+			if ab.agent != nil {
+				agentSigners, err := ab.agent.Signers()
+				if err != nil {
+					return nil, errors.Wrap(err, "cannot get signers of ssh-agent")
+				}
+				signers = append(signers[:len(signers):len(signers)], agentSigners...)
+			}
+			return signers, nil
+		}))
+	}
+	if ab.keyboardInteractive != nil {
+		methods = append(methods, ssh.KeyboardInteractive(ab.keyboardInteractive))
+	}
+	if ab.password != "" {
+		methods = append(methods, ssh.Password(ab.password))
+	}
+	return methods, AuthIdentity(publicKeys, ab.password), nil
+}
+
+// ForwardAgent forwards the agent of the builder to session on conn
+func (ab *AuthBuilder) ForwardAgent(conn *Connection, session *ssh.Session) error {
+	if ab.agent == nil {
+		return errors.New("no ssh-agent configured")
+	}
+	if err := agent.ForwardToAgent(conn.GetClient(), ab.agent); err != nil {
+		return errors.Wrap(err, "cannot forward ssh-agent")
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return errors.Wrap(err, "cannot request agent forwarding")
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newPublicKeyServer starts a server accepting user test with the public key of accepted
+func newPublicKeyServer(t *testing.T, accepted ssh.PublicKey) net.Listener {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "test" && bytes.Equal(key.Marshal(), accepted.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+	return newTestServerWithConfig(t, config)
+}
+
+func connectWithAuth(t *testing.T, listener net.Listener, builder *AuthBuilder) error {
+	t.Helper()
+	s, err := NewSFTP(nil, "", "", 4, 16, 32*1024, nil, logging.MustGetLogger("test"), builder)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	_, err = s.GetConnection(testURL(listener, "/"))
+	return err
+}
+
+func TestAuthEncryptedKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newPublicKeyServer(t, signer.PublicKey())
+
+	if _, _, err := NewAuthBuilder().WithPrivateKeyFile(keyFile, nil).Build(); err == nil {
+		t.Fatal("encrypted key without passphrase accepted")
+	}
+	wrong := func(string) ([]byte, error) { return []byte("wrong"), nil }
+	if _, _, err := NewAuthBuilder().WithPrivateKeyFile(keyFile, wrong).Build(); err == nil {
+		t.Fatal("wrong passphrase accepted")
+	}
+	t.Setenv("TEST_SSH_PASSPHRASE", "passphrase")
+	builder := NewAuthBuilder().WithPrivateKeyFile(keyFile, EnvStringPassphrase("%%TEST_SSH_PASSPHRASE%%"))
+	if err := connectWithAuth(t, listener, builder); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthCertificate(t *testing.T) {
+	caSigner := newTestSigner(t)
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: []string{"test"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), caSigner.PublicKey().Marshal())
+		},
+	}
+	config := &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	config.AddHostKey(newTestSigner(t))
+	listener := newTestServerWithConfig(t, config)
+
+	// the certificate is found next to the key
+	if err := connectWithAuth(t, listener, NewAuthBuilder().WithPrivateKeyFile(keyFile, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := connectWithAuth(t, listener, NewAuthBuilder().WithSigner(signer)); err == nil {
+		t.Fatal("plain key accepted by certificate server")
+	}
+	otherKey := filepath.Join(t.TempDir(), "other")
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err = ssh.MarshalPrivateKey(otherPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(otherKey, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewAuthBuilder().WithCertificateFile(otherKey, keyFile+"-cert.pub", nil).Build(); err == nil {
+		t.Fatal("certificate of another key accepted")
+	}
+	// an unrelated certificate next to the key is ignored with a warning
+	if err := os.WriteFile(otherKey+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+	builder := NewAuthBuilder().WithPrivateKeyFile(otherKey, nil)
+	if methods, _, err := builder.Build(); err != nil || len(methods) != 1 {
+		t.Fatalf("plain key not used: %v", err)
+	}
+	if len(builder.Warnings()) != 1 {
+		t.Errorf("ignored certificate not reported: %v", builder.Warnings())
+	}
+}
+
+func TestAuthAgent(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	// unix socket paths are limited, so the short default temp dir is used
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "sock")
+	agentListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { agentListener.Close() })
+	go func() {
+		for {
+			conn, err := agentListener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newPublicKeyServer(t, signer.PublicKey())
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	builder := NewAuthBuilder().WithAgent("")
+	if err := connectWithAuth(t, listener, builder); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := builder.Build(); err == nil {
+		t.Error("agent used after close")
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := NewAuthBuilder().WithAgent("").Build(); err == nil {
+		t.Fatal("missing agent not reported")
+	}
+}
+
+func TestAuthKeyboardInteractive(t *testing.T) {
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("test", "", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) == 1 && answers[0] == "123456" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+	listener := newTestServerWithConfig(t, config)
+
+	challenge := func(answer string) ssh.KeyboardInteractiveChallenge {
+		return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = answer
+			}
+			return answers, nil
+		}
+	}
+	if err := connectWithAuth(t, listener, NewAuthBuilder().WithKeyboardInteractive(challenge("123456"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := connectWithAuth(t, listener, NewAuthBuilder().WithKeyboardInteractive(challenge("000000"))); err == nil {
+		t.Fatal("wrong answer accepted")
+	}
+}
+
+func TestNewSSHTunnelWithoutAuth(t *testing.T) {
+	if _, err := NewSSHTunnel("test", "", &Endpoint{Host: "localhost", Port: 22}, nil, logging.MustGetLogger("test")); err == nil {
+		t.Fatal("tunnel without authentication created")
+	}
+	if _, err := NewSSHTunnel("test", "", &Endpoint{Host: "localhost", Port: 22}, nil, logging.MustGetLogger("test"), NewAuthBuilder().WithPassword(testPassword)); err != nil {
+		t.Fatal(err)
+	}
+}
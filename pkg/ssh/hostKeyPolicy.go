@@ -0,0 +1,361 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMode selects how host keys are verified
+type HostKeyMode string
+
+const (
+	// HostKeyInsecure accepts every host key
+	HostKeyInsecure HostKeyMode = "insecure"
+	// HostKeyStrict accepts only host keys listed in KnownHosts
+	HostKeyStrict HostKeyMode = "strict"
+	// HostKeyTOFU trusts the key of an unknown host on first use and appends it to KnownHosts
+	HostKeyTOFU HostKeyMode = "tofu"
+	// HostKeyPinned accepts only host keys with one of the Fingerprints
+	HostKeyPinned HostKeyMode = "pinned"
+	// HostKeyCA accepts only host certificates signed by one of the CAKeys or @cert-authority entries of KnownHosts.
+	// Keys marked @revoked in KnownHosts are rejected
+	HostKeyCA HostKeyMode = "ca"
+)
+
+// HostKeyReason is the reason of a HostKeyError
+type HostKeyReason string
+
+const (
+	UnknownHostKey         HostKeyReason = "unknown host"
+	MismatchedHostKey      HostKeyReason = "host key mismatch"
+	RevokedHostKey         HostKeyReason = "host key revoked"
+	UnpinnedHostKey        HostKeyReason = "host key not pinned"
+	MissingHostCertificate HostKeyReason = "no host certificate"
+	InvalidHostCertificate HostKeyReason = "invalid host certificate"
+)
+
+// HostKeyError is returned if a host key is rejected by a HostKeyPolicy
+type HostKeyError struct {
+	Hostname    string
+	Fingerprint string
+	Reason      HostKeyReason
+	// Want are the fingerprints of the known keys on mismatch
+	Want []string
+	Err  error
+}
+
+func (hke *HostKeyError) Error() string {
+	msg := fmt.Sprintf("%s: %s (%s)", hke.Hostname, hke.Reason, hke.Fingerprint)
+	if len(hke.Want) > 0 {
+		msg += fmt.Sprintf(", expected %s", strings.Join(hke.Want, " or "))
+	}
+	if hke.Err != nil {
+		msg += ": " + hke.Err.Error()
+	}
+	return msg
+}
+
+func (hke *HostKeyError) Unwrap() error {
+	return hke.Err
+}
+
+// HostKeyPolicy configures the host key verification of SFTP and SSHtunnel
+type HostKeyPolicy struct {
+	Mode HostKeyMode
+	// KnownHosts is the known_hosts file of HostKeyStrict, HostKeyTOFU and HostKeyCA
+	KnownHosts string
+	// Fingerprints are the SHA256 fingerprints of HostKeyPinned, e.g. "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+	Fingerprints []string
+	// CAKeys are the certificate authorities of HostKeyCA in authorized_keys format. They are valid for every host
+	CAKeys []string
+	// LockTimeout is the maximum time to wait for the lock of KnownHosts in HostKeyTOFU. Default is 10 seconds
+	LockTimeout time.Duration
+}
+
+// NewStrictHostKeyPolicy returns a policy accepting the keys of knownHosts only
+func NewStrictHostKeyPolicy(knownHosts string) *HostKeyPolicy {
+	return &HostKeyPolicy{Mode: HostKeyStrict, KnownHosts: knownHosts}
+}
+
+// Callback creates the ssh.HostKeyCallback of the policy
+func (p *HostKeyPolicy) Callback() (ssh.HostKeyCallback, error) {
+	switch p.Mode {
+	case HostKeyInsecure, "":
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict:
+		callback, err := knownhosts.New(p.KnownHosts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create hostkeycallback function for %s", p.KnownHosts)
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return knownHostsError(hostname, key, callback(hostname, remote, key))
+		}, nil
+	case HostKeyTOFU:
+		if p.KnownHosts == "" {
+			return nil, errors.New("trust on first use needs a known_hosts file")
+		}
+		return p.tofuCallback, nil
+	case HostKeyPinned:
+		if len(p.Fingerprints) == 0 {
+			return nil, errors.New("no pinned fingerprints")
+		}
+		return p.pinnedCallback, nil
+	case HostKeyCA:
+		return p.caCallback()
+	default:
+		return nil, errors.Errorf("unknown host key mode '%s'", p.Mode)
+	}
+}
+
+// HostKeyAlgorithms returns the host key algorithms to request. Certificates are preferred in HostKeyCA.
+// Plain keys are offered as well, so that their rejection is reported as MissingHostCertificate
+func (p *HostKeyPolicy) HostKeyAlgorithms() []string {
+	if p.Mode != HostKeyCA {
+		return nil
+	}
+	return []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+}
+
+// apply sets the host key verification of config
+func (p *HostKeyPolicy) apply(config *ssh.ClientConfig) error {
+	callback, err := p.Callback()
+	if err != nil {
+		return err
+	}
+	config.HostKeyCallback = callback
+	config.HostKeyAlgorithms = p.HostKeyAlgorithms()
+	return nil
+}
+
+// identity returns a pool key component of the policy, so that connections verified with another policy are not reused
+func (p *HostKeyPolicy) identity() string {
+	if p.Mode == HostKeyInsecure || p.Mode == "" {
+		return string(HostKeyInsecure)
+	}
+	h := sha256.New()
+	h.Write([]byte(p.KnownHosts))
+	h.Write([]byte{0})
+	for _, values := range [][]string{p.Fingerprints, p.CAKeys} {
+		for _, value := range values {
+			h.Write([]byte(value))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{0})
+	}
+	return string(p.Mode) + "-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// knownHostsError converts the errors of knownhosts to HostKeyError
+func knownHostsError(hostname string, key ssh.PublicKey, err error) error {
+	if err == nil {
+		return nil
+	}
+	hke := &HostKeyError{Hostname: hostname, Fingerprint: ssh.FingerprintSHA256(key), Err: err}
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case errors.As(err, &keyErr):
+		if len(keyErr.Want) == 0 {
+			hke.Reason = UnknownHostKey
+		} else {
+			hke.Reason = MismatchedHostKey
+			for _, want := range keyErr.Want {
+				hke.Want = append(hke.Want, ssh.FingerprintSHA256(want.Key))
+			}
+		}
+		// the reason and the fingerprints say it all
+		hke.Err = nil
+	case errors.As(err, &revokedErr):
+		hke.Reason = RevokedHostKey
+	default:
+		return err
+	}
+	return hke
+}
+
+// knownHostsLock serializes TOFU updates within the process, the lock file between processes
+var knownHostsLock sync.Mutex
+
+func (p *HostKeyPolicy) tofuCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	unlock, err := lockFile(p.KnownHosts+".lock", p.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the file is read every time, since other processes may have added keys
+	if _, err := os.Stat(p.KnownHosts); err == nil {
+		callback, err := knownhosts.New(p.KnownHosts)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read %s", p.KnownHosts)
+		}
+		err = knownHostsError(hostname, key, callback(hostname, remote, key))
+		var hke *HostKeyError
+		if !errors.As(err, &hke) || hke.Reason != UnknownHostKey {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot stat %s", p.KnownHosts)
+	}
+	fp, err := os.OpenFile(p.KnownHosts, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", p.KnownHosts)
+	}
+	defer fp.Close()
+	if _, err := fmt.Fprintln(fp, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return errors.Wrapf(err, "cannot add host key to %s", p.KnownHosts)
+	}
+	return nil
+}
+
+// lockFile creates the lock file name exclusively. Lock files older than timeout are considered stale
+func lockFile(name string, timeout time.Duration) (func(), error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		fp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fp.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "cannot create lock file %s", name)
+		}
+		if stat, err := os.Stat(name); err == nil && time.Since(stat.ModTime()) > timeout {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("timeout waiting for lock file %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (p *HostKeyPolicy) pinnedCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, pinned := range p.Fingerprints {
+		if fingerprint == pinned || fingerprint == "SHA256:"+pinned {
+			return nil
+		}
+	}
+	return &HostKeyError{Hostname: hostname, Fingerprint: fingerprint, Reason: UnpinnedHostKey, Want: p.Fingerprints}
+}
+
+// certAuthorities reads CAKeys and the @revoked lines of KnownHosts. knownHostsCA reports whether
+// KnownHosts contains @cert-authority lines
+func (p *HostKeyPolicy) certAuthorities() (caKeys []ssh.PublicKey, revoked map[string]bool, knownHostsCA bool, err error) {
+	revoked = map[string]bool{}
+	for _, caKey := range p.CAKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(caKey))
+		if err != nil {
+			return nil, nil, false, errors.Wrapf(err, "cannot parse ca key '%s'", caKey)
+		}
+		caKeys = append(caKeys, key)
+	}
+	if p.KnownHosts != "" {
+		data, err := os.ReadFile(p.KnownHosts)
+		if err != nil {
+			return nil, nil, false, errors.Wrapf(err, "cannot read %s", p.KnownHosts)
+		}
+		for {
+			marker, _, key, _, rest, err := ssh.ParseKnownHosts(data)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, false, errors.Wrapf(err, "cannot parse %s", p.KnownHosts)
+			}
+			switch marker {
+			case "cert-authority":
+				knownHostsCA = true
+			case "revoked":
+				revoked[string(key.Marshal())] = true
+			}
+			data = rest
+		}
+	}
+	if len(caKeys) == 0 && !knownHostsCA {
+		return nil, nil, false, errors.New("no certificate authorities")
+	}
+	return caKeys, revoked, knownHostsCA, nil
+}
+
+// caCallback accepts host certificates of CAKeys for every host. The @cert-authority lines of KnownHosts
+// are checked by knownhosts, which handles negated and hashed host patterns. Certificates with a revoked
+// host key or signed by a revoked CA are rejected
+func (p *HostKeyPolicy) caCallback() (ssh.HostKeyCallback, error) {
+	caKeys, revoked, knownHostsCA, err := p.certAuthorities()
+	if err != nil {
+		return nil, err
+	}
+	var knownHostsCallback ssh.HostKeyCallback
+	if knownHostsCA {
+		if knownHostsCallback, err = knownhosts.New(p.KnownHosts); err != nil {
+			return nil, errors.Wrapf(err, "could not create hostkeycallback function for %s", p.KnownHosts)
+		}
+	}
+	var isCAKey = func(auth ssh.PublicKey) bool {
+		for _, caKey := range caKeys {
+			if bytes.Equal(caKey.Marshal(), auth.Marshal()) {
+				return true
+			}
+		}
+		return false
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return isCAKey(auth)
+		},
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hke := &HostKeyError{Hostname: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			hke.Reason = MissingHostCertificate
+			return hke
+		}
+		if revoked[string(cert.Key.Marshal())] || revoked[string(cert.SignatureKey.Marshal())] {
+			hke.Reason = RevokedHostKey
+			return hke
+		}
+		var err error
+		switch {
+		case isCAKey(cert.SignatureKey):
+			err = checker.CheckHostKey(hostname, remote, key)
+		case knownHostsCallback != nil:
+			err = knownHostsCallback(hostname, remote, key)
+		default:
+			err = errors.New("certificate not signed by a certificate authority")
+		}
+		if err != nil {
+			hke.Reason = InvalidHostCertificate
+			hke.Err = err
+			return hke
+		}
+		return nil
+	}, nil
+}
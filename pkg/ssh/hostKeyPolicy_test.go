@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// connectWithPolicy connects to the test server with a new SFTP using policy
+func connectWithPolicy(t *testing.T, address string, policy *HostKeyPolicy) error {
+	t.Helper()
+	s := newTestSFTP(t)
+	defer s.Close()
+	if err := s.SetHostKeyPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.GetConnection(&url.URL{Scheme: "sftp", User: url.User("test"), Host: address, Path: "/"})
+	return err
+}
+
+func expectReason(t *testing.T, err error, reason HostKeyReason) {
+	t.Helper()
+	var hke *HostKeyError
+	if !errors.As(err, &hke) || hke.Reason != reason {
+		t.Errorf("expected %s, got %v", reason, err)
+	}
+}
+
+func TestHostKeyPolicy(t *testing.T) {
+	hostSigner := newTestSigner(t)
+	address := newTestServerWithHostKey(t, hostSigner).Addr().String()
+	otherKey := newTestSigner(t).PublicKey()
+	dir := t.TempDir()
+
+	// strict
+	knownHosts := filepath.Join(dir, "known_hosts")
+	os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(address)}, hostSigner.PublicKey())+"\n"), 0600)
+	if err := connectWithPolicy(t, address, NewStrictHostKeyPolicy(knownHosts)); err != nil {
+		t.Errorf("strict: %v", err)
+	}
+	wrongHosts := filepath.Join(dir, "wrong_hosts")
+	os.WriteFile(wrongHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(address)}, otherKey)+"\n"), 0600)
+	expectReason(t, connectWithPolicy(t, address, NewStrictHostKeyPolicy(wrongHosts)), MismatchedHostKey)
+	emptyHosts := filepath.Join(dir, "empty_hosts")
+	os.WriteFile(emptyHosts, nil, 0600)
+	expectReason(t, connectWithPolicy(t, address, NewStrictHostKeyPolicy(emptyHosts)), UnknownHostKey)
+
+	// trust on first use, concurrently
+	tofuHosts := filepath.Join(dir, "tofu_hosts")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyTOFU, KnownHosts: tofuHosts}); err != nil {
+				t.Errorf("tofu: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	data, err := os.ReadFile(tofuHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("expected one known host, got %d", lines)
+	}
+	expectReason(t, connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyTOFU, KnownHosts: wrongHosts}), MismatchedHostKey)
+
+	// pinned
+	if err := connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyPinned, Fingerprints: []string{ssh.FingerprintSHA256(otherKey), ssh.FingerprintSHA256(hostSigner.PublicKey())}}); err != nil {
+		t.Errorf("pinned: %v", err)
+	}
+	expectReason(t, connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyPinned, Fingerprints: []string{ssh.FingerprintSHA256(otherKey)}}), UnpinnedHostKey)
+}
+
+func TestHostKeyPolicyCA(t *testing.T) {
+	caSigner := newTestSigner(t)
+	hostSigner := newTestSigner(t)
+	cert := &ssh.Certificate{
+		Key:             hostSigner.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, hostSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := newTestServerWithHostKey(t, certSigner).Addr().String()
+	plainAddress := newTestServerWithHostKey(t, hostSigner).Addr().String()
+	caKey := string(ssh.MarshalAuthorizedKey(caSigner.PublicKey()))
+
+	if err := connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyCA, CAKeys: []string{caKey}}); err != nil {
+		t.Errorf("ca: %v", err)
+	}
+	// the host patterns of known_hosts follow OpenSSH, including the port, negations and hashes
+	dir := t.TempDir()
+	_, port, _ := net.SplitHostPort(address)
+	for _, pattern := range []string{knownhosts.Normalize(address), "[*]:" + port, knownhosts.HashHostname(knownhosts.Normalize(address))} {
+		knownHosts := filepath.Join(dir, "known_hosts")
+		os.WriteFile(knownHosts, []byte("@cert-authority "+pattern+" "+caKey), 0600)
+		if err := connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyCA, KnownHosts: knownHosts}); err != nil {
+			t.Errorf("ca from known_hosts with pattern %s: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"127.0.0.1", "[*]:" + port + ",!" + knownhosts.Normalize(address)} {
+		knownHosts := filepath.Join(dir, "known_hosts")
+		os.WriteFile(knownHosts, []byte("@cert-authority "+pattern+" "+caKey), 0600)
+		expectReason(t, connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyCA, KnownHosts: knownHosts}), InvalidHostCertificate)
+	}
+	// revoked host keys and certificate authorities
+	for _, key := range []ssh.PublicKey{hostSigner.PublicKey(), caSigner.PublicKey()} {
+		knownHosts := filepath.Join(dir, "known_hosts")
+		os.WriteFile(knownHosts, []byte("@revoked * "+string(ssh.MarshalAuthorizedKey(key))), 0600)
+		expectReason(t, connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyCA, KnownHosts: knownHosts, CAKeys: []string{caKey}}), RevokedHostKey)
+	}
+	otherCA := string(ssh.MarshalAuthorizedKey(newTestSigner(t).PublicKey()))
+	expectReason(t, connectWithPolicy(t, address, &HostKeyPolicy{Mode: HostKeyCA, CAKeys: []string{otherCA}}), InvalidHostCertificate)
+	expectReason(t, connectWithPolicy(t, plainAddress, &HostKeyPolicy{Mode: HostKeyCA, CAKeys: []string{caKey}}), MissingHostCertificate)
+}
+
+func TestHostKeyPolicyOptions(t *testing.T) {
+	hostSigner := newTestSigner(t)
+	listener := newTestServerWithHostKey(t, hostSigner)
+	otherKey := newTestSigner(t).PublicKey()
+	log := logging.MustGetLogger("test")
+
+	if _, err := NewSFTPWithOptions(&SFTPOptions{Auth: NewAuthBuilder().WithPassword(testPassword)}, log); err == nil {
+		t.Error("sftp without host key policy created")
+	}
+	if _, err := NewSSHTunnelWithOptions(&Endpoint{Host: "localhost", Port: 22}, nil, &TunnelOptions{User: "test", Auth: NewAuthBuilder().WithPassword(testPassword)}, log); err == nil {
+		t.Error("tunnel without host key policy created")
+	}
+	s, err := NewSFTPWithOptions(&SFTPOptions{
+		Auth:          NewAuthBuilder().WithPassword(testPassword),
+		HostKeyPolicy: &HostKeyPolicy{Mode: HostKeyPinned, Fingerprints: []string{ssh.FingerprintSHA256(otherKey)}},
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	expectReason(t, func() error { _, err := s.GetConnection(testURL(listener, "/")); return err }(), UnpinnedHostKey)
+
+	// a connection of another policy is not reused
+	if err := s.SetHostKeyPolicy(&HostKeyPolicy{Mode: HostKeyInsecure}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetConnection(testURL(listener, "/")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetHostKeyPolicy(&HostKeyPolicy{Mode: HostKeyPinned, Fingerprints: []string{ssh.FingerprintSHA256(otherKey)}}); err != nil {
+		t.Fatal(err)
+	}
+	expectReason(t, func() error { _, err := s.GetConnection(testURL(listener, "/")); return err }(), UnpinnedHostKey)
+}
@@ -5,9 +5,7 @@ import (
 	"github.com/op/go-logging"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 	"io"
-	"io/ioutil"
 	"net/url"
 	"os"
 	"time"
@@ -21,66 +19,132 @@ type SFTP struct {
 	maxClientConcurrency int
 	maxPacketSize        int
 	rsc                  *stream.ReadStreamQueue
+	// authIdentity distinguishes pooled connections with different authentication
+	authIdentity string
+	// identity distinguishes pooled connections with different authentication and host key verification
+	identity string
 }
 
-func NewSFTP(PrivateKey []string, Password, KnownHosts string, concurrency, maxClientConcurrency, maxPacketSize int, rsc *stream.ReadStreamQueue, log *logging.Logger) (*SFTP, error) {
-	var signer []ssh.Signer
+// SFTPOptions configures NewSFTPWithOptions
+type SFTPOptions struct {
+	// Auth are the authentication methods
+	Auth *AuthBuilder
+	// HostKeyPolicy verifies the host keys. It is required, HostKeyInsecure has to be chosen explicitly
+	HostKeyPolicy *HostKeyPolicy
+	// Pool configures the connection pool. Default is DefaultPoolOptions
+	Pool                 *PoolOptions
+	Concurrency          int
+	MaxClientConcurrency int
+	MaxPacketSize        int
+	ReadStreamQueue      *stream.ReadStreamQueue
+}
+
+// NewSFTP creates an SFTP client. Additional authentication methods like ssh-agent,
+// encrypted keys or certificates are added with auth. Without KnownHosts, host keys are not verified
+func NewSFTP(PrivateKey []string, Password, KnownHosts string, concurrency, maxClientConcurrency, maxPacketSize int, rsc *stream.ReadStreamQueue, log *logging.Logger, auth ...*AuthBuilder) (*SFTP, error) {
+	builder := NewAuthBuilder()
+	for _, pk := range PrivateKey {
+		builder.WithPrivateKeyFile(pk, nil)
+	}
+	if Password != "" {
+		builder.WithPassword(Password)
+	}
+	for _, ab := range auth {
+		builder.merge(ab)
+	}
+	policy := &HostKeyPolicy{Mode: HostKeyInsecure}
+	if KnownHosts != "" {
+		policy = NewStrictHostKeyPolicy(KnownHosts)
+	}
+	return NewSFTPWithOptions(&SFTPOptions{
+		Auth:                 builder,
+		HostKeyPolicy:        policy,
+		Concurrency:          concurrency,
+		MaxClientConcurrency: maxClientConcurrency,
+		MaxPacketSize:        maxPacketSize,
+		ReadStreamQueue:      rsc,
+	}, log)
+}
 
-	readStreamQueue, err := stream.NewReadStreamQueue(rsc)
+// NewSFTPWithOptions creates an SFTP client which verifies host keys with opts.HostKeyPolicy
+func NewSFTPWithOptions(opts *SFTPOptions, log *logging.Logger) (*SFTP, error) {
+	if opts.HostKeyPolicy == nil {
+		return nil, errors.New("no host key policy")
+	}
+	readStreamQueue, err := stream.NewReadStreamQueue(opts.ReadStreamQueue)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create ReadStreamQueue")
 	}
+	poolOptions := opts.Pool
+	if poolOptions == nil {
+		poolOptions = DefaultPoolOptions()
+	}
 
 	sftp := &SFTP{
 		log: log,
 		config: &ssh.ClientConfig{
-			Auth:            []ssh.AuthMethod{},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Auth: []ssh.AuthMethod{},
 		},
-		pool:                 NewConnectionPool(log),
-		concurrency:          concurrency,
-		maxClientConcurrency: maxClientConcurrency,
-		maxPacketSize:        maxPacketSize,
+		concurrency:          opts.Concurrency,
+		maxClientConcurrency: opts.MaxClientConcurrency,
+		maxPacketSize:        opts.MaxPacketSize,
 		rsc:                  readStreamQueue,
 	}
-
-	for _, pk := range PrivateKey {
-		key, err := ioutil.ReadFile(pk)
-		if err != nil {
-			return nil, errors.Wrapf(err, "cannot read private key file %s")
-		}
-		// Create the Signer for this private key.
-		s, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, errors.Wrapf(err, "unable to parse private key %v", string(key))
-		}
-		signer = append(signer, s)
-	}
-	if len(signer) > 0 {
-		sftp.config.Auth = append(sftp.config.Auth, ssh.PublicKeys(signer...))
+	builder := opts.Auth
+	if builder == nil {
+		builder = NewAuthBuilder()
 	}
-	if KnownHosts != "" {
-		hostKeyCallback, err := knownhosts.New(KnownHosts)
-		if err != nil {
-			return nil, errors.Wrapf(err, "could not create hostkeycallback function for %s", KnownHosts)
-		}
-		sftp.config.HostKeyCallback = hostKeyCallback
+	sftp.config.Auth, sftp.authIdentity, err = builder.Build()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create authentication methods")
 	}
-	if Password != "" {
-		sftp.config.Auth = append(sftp.config.Auth, ssh.Password(Password))
+	for _, warning := range builder.Warnings() {
+		log.Warning(warning)
+	}
+	if err := sftp.SetHostKeyPolicy(opts.HostKeyPolicy); err != nil {
+		return nil, err
 	}
+	sftp.pool = NewConnectionPoolWithOptions(poolOptions, log)
 	return sftp, nil
 }
 
+// SetHostKeyPolicy sets the host key verification for new connections.
+// Pooled connections verified with another policy are not reused
+func (s *SFTP) SetHostKeyPolicy(policy *HostKeyPolicy) error {
+	if err := policy.apply(s.config); err != nil {
+		return err
+	}
+	s.identity = s.authIdentity + "/" + policy.identity()
+	return nil
+}
+
+// SetConnectionPool replaces the connection pool, e.g. to use other PoolOptions. The old pool is closed
+func (s *SFTP) SetConnectionPool(pool *ConnectionPool) error {
+	old := s.pool
+	s.pool = pool
+	return old.Close()
+}
+
 func (s *SFTP) GetConnection(address *url.URL) (*Connection, error) {
-	return s.pool.GetConnection(address, s.config)
+	return s.pool.GetConnection(address, s.config, s.identity)
+}
+
+// AcquireConnection returns the pooled connection for address marked as in use. The caller must Release it
+func (s *SFTP) AcquireConnection(address *url.URL) (*Connection, error) {
+	return s.pool.AcquireConnection(address, s.config, s.identity)
+}
+
+// Close closes all pooled connections
+func (s *SFTP) Close() error {
+	return s.pool.Close()
 }
 
 func (s *SFTP) Get(uri *url.URL, w io.Writer) (int64, error) {
-	conn, err := s.GetConnection(uri)
+	conn, err := s.AcquireConnection(uri)
 	if err != nil {
 		return 0, errors.Wrapf(err, "unable to connect to %v with user %v", uri.String(), uri.User.Username())
 	}
+	defer conn.Release()
 	sConn, err := NewSFTPConnection(conn, s.concurrency, s.maxClientConcurrency, s.maxPacketSize)
 	if err != nil {
 		return 0, errors.Wrapf(err, "unable to create sftp connection for %s", uri.String())
@@ -111,10 +175,11 @@ func (s *SFTP) PutFile(uri *url.URL, source string) (int64, error) {
 }
 
 func (s *SFTP) Put(uri *url.URL, r io.Reader) (int64, error) {
-	conn, err := s.GetConnection(uri)
+	conn, err := s.AcquireConnection(uri)
 	if err != nil {
 		return 0, errors.Wrapf(err, "unable to connect to %v with user %v", uri.String(), uri.User.Username())
 	}
+	defer conn.Release()
 	sConn, err := NewSFTPConnection(conn, s.concurrency, s.maxClientConcurrency, s.maxPacketSize)
 	if err != nil {
 		return 0, errors.Wrapf(err, "unable to create sftp connection for %s", uri.String())
@@ -24,15 +24,15 @@ func NewSFTPConnection(sshConn *Connection, concurrency, maxClientConcurrency, m
 }
 
 func (sc *SFTPConnection) GetSFTPClient() (*sftp.Client, error) {
-	sftpclient, err := sftp.NewClient(sc.Client, sftp.MaxPacket(sc.maxPacketSize), sftp.MaxConcurrentRequestsPerFile(sc.maxClientConcurrency))
+	sftpclient, err := sftp.NewClient(sc.GetClient(), sftp.MaxPacket(sc.maxPacketSize), sftp.MaxConcurrentRequestsPerFile(sc.maxClientConcurrency))
 	if err != nil {
-		sc.Log.Infof("cannot get sftp subsystem - reconnecting to %s@%s", sc.Client.User(), sc.Address)
+		sc.Log.Infof("cannot get sftp subsystem - reconnecting to %s@%s", sc.config.User, sc.Address)
 		if err := sc.Connect(); err != nil {
-			return nil, errors.Wrapf(err, "cannot connect with ssh to %s@%s", sc.Client.User(), sc.Address)
+			return nil, errors.Wrapf(err, "cannot connect with ssh to %s@%s", sc.config.User, sc.Address)
 		}
-		sftpclient, err = sftp.NewClient(sc.Client)
+		sftpclient, err = sftp.NewClient(sc.GetClient(), sftp.MaxPacket(sc.maxPacketSize), sftp.MaxConcurrentRequestsPerFile(sc.maxClientConcurrency))
 		if err != nil {
-			return nil, errors.Wrapf(err, "cannot create sftp client on %s@%s", sc.Client.User(), sc.Address)
+			return nil, errors.Wrapf(err, "cannot create sftp client on %s@%s", sc.config.User, sc.Address)
 		}
 	}
 	return sftpclient, nil
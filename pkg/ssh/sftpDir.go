@@ -0,0 +1,432 @@
+package ssh
+
+import (
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/je4/utils/v2/pkg/checksum"
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+)
+
+// SyncMode selects how PutDir and GetDir detect unchanged files
+type SyncMode int
+
+const (
+	// SyncOff copies every file
+	SyncOff SyncMode = iota
+	// SyncSizeModTime skips files with the same size and modification time
+	SyncSizeModTime
+	// SyncChecksum skips files with the same size and checksum
+	SyncChecksum
+)
+
+// DirOptions configures PutDir and GetDir
+type DirOptions struct {
+	// Include limits the files to the ones matching one of the glob patterns. Patterns without "/" match the
+	// base name, others the path relative to the root. Directories are always traversed
+	Include []string
+	// Exclude skips files and directories matching one of the glob patterns
+	Exclude []string
+	// Sync skips unchanged files
+	Sync SyncMode
+	// Checksum is the digest of SyncChecksum. Default is sha256
+	Checksum checksum.DigestAlgorithm
+	// DryRun only reports the actions without changing the target
+	DryRun bool
+}
+
+// DirAction is the action taken for an entry by PutDir or GetDir
+type DirAction string
+
+const (
+	DirActionMkdir DirAction = "mkdir"
+	DirActionCopy  DirAction = "copy"
+	DirActionSkip  DirAction = "skip"
+)
+
+// DirEntryReport is the action for a file or directory relative to the root
+type DirEntryReport struct {
+	Path   string
+	Action DirAction
+	Size   int64
+}
+
+// DirReport is the result of PutDir or GetDir
+type DirReport struct {
+	DryRun   bool
+	Entries  []DirEntryReport
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Count returns the number of entries with action
+func (dr *DirReport) Count(action DirAction) int {
+	var count int
+	for _, entry := range dr.Entries {
+		if entry.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func matchPatterns(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		subject := name
+		if !strings.Contains(pattern, "/") {
+			subject = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selected checks the include and exclude patterns for the relative path name
+func (opts *DirOptions) selected(name string, isDir bool) bool {
+	if matchPatterns(opts.Exclude, name) {
+		return false
+	}
+	return isDir || len(opts.Include) == 0 || matchPatterns(opts.Include, name)
+}
+
+// unchanged compares source and target according to the sync mode
+func (opts *DirOptions) unchanged(source, target fs.FileInfo, openSource, openTarget func() (io.ReadCloser, error)) (bool, error) {
+	if target == nil || target.IsDir() || source.Size() != target.Size() {
+		return false, nil
+	}
+	switch opts.Sync {
+	case SyncSizeModTime:
+		// sftp transfers modification times in seconds
+		return source.ModTime().Unix() == target.ModTime().Unix(), nil
+	case SyncChecksum:
+		var digests = make([]string, 2)
+		for i, open := range []func() (io.ReadCloser, error){openSource, openTarget} {
+			rc, err := open()
+			if err != nil {
+				return false, err
+			}
+			digests[i], err = checksum.Checksum(rc, opts.Checksum)
+			rc.Close()
+			if err != nil {
+				return false, err
+			}
+		}
+		return digests[0] == digests[1], nil
+	default:
+		return false, nil
+	}
+}
+
+func (opts *DirOptions) withDefaults() *DirOptions {
+	var result DirOptions
+	if opts != nil {
+		result = *opts
+	}
+	if result.Checksum == "" {
+		result.Checksum = checksum.DigestSHA256
+	}
+	return &result
+}
+
+// dirAttrs holds the directories of a transfer. Their permissions and modification times are set after
+// their content is written, so that read-only directories can be filled and the times are not changed by
+// the content. With include patterns, missing directories are created only when a file below them is copied
+type dirAttrs struct {
+	dirs  []*dirAttr
+	names map[string]*dirAttr
+}
+
+type dirAttr struct {
+	name    string
+	path    string
+	info    fs.FileInfo
+	pending bool
+}
+
+func newDirAttrs() *dirAttrs {
+	return &dirAttrs{names: map[string]*dirAttr{}}
+}
+
+// add registers the directory name at path p. A pending directory does not exist yet
+func (da *dirAttrs) add(name, p string, info fs.FileInfo, pending bool) {
+	dir := &dirAttr{name: name, path: p, info: info, pending: pending}
+	da.dirs = append(da.dirs, dir)
+	da.names[name] = dir
+}
+
+// create creates the pending parent directories of the file name and reports them
+func (da *dirAttrs) create(name string, report *DirReport, mkdir func(p string) error) error {
+	var pending []*dirAttr
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if attr, ok := da.names[dir]; ok && attr.pending {
+			pending = append(pending, attr)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		pending[i].pending = false
+		report.Entries = append(report.Entries, DirEntryReport{Path: pending[i].name, Action: DirActionMkdir})
+	}
+	if report.DryRun {
+		return nil
+	}
+	if err := mkdir(pending[0].path); err != nil {
+		return errors.Wrapf(err, "cannot create directory %s", pending[0].path)
+	}
+	return nil
+}
+
+// apply sets permissions and times in reverse order, so that subdirectories are done before their parents
+func (da *dirAttrs) apply(chmod func(p string, mode fs.FileMode) error, chtimes func(p string, mtime time.Time) error) error {
+	for i := len(da.dirs) - 1; i >= 0; i-- {
+		dir := da.dirs[i]
+		if dir.pending {
+			continue
+		}
+		if err := chmod(dir.path, dir.info.Mode().Perm()); err != nil {
+			return errors.Wrapf(err, "cannot set permissions of %s", dir.path)
+		}
+		if err := chtimes(dir.path, dir.info.ModTime()); err != nil {
+			return errors.Wrapf(err, "cannot set times of %s", dir.path)
+		}
+	}
+	return nil
+}
+
+// PutDir uploads the content of fsys to the directory uri. Modification times and permissions are preserved
+func (s *SFTP) PutDir(uri *url.URL, fsys fs.FS, opts *DirOptions) (*DirReport, error) {
+	opts = opts.withDefaults()
+	sConn, err := s.getSFTPConnection(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer sConn.Release()
+	client, err := sConn.GetSFTPClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create SFTP session")
+	}
+	defer client.Close()
+
+	report := &DirReport{DryRun: opts.DryRun}
+	start := time.Now()
+	var dirs = newDirAttrs()
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && !opts.selected(name, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrapf(err, "cannot stat %s", name)
+		}
+		target := path.Join(uri.Path, name)
+		targetInfo, err := client.Stat(target)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errors.Wrapf(err, "cannot stat remote %s", target)
+		}
+		if d.IsDir() {
+			pending := targetInfo == nil && name != "." && len(opts.Include) > 0
+			if targetInfo == nil && !pending {
+				report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionMkdir})
+				if !opts.DryRun {
+					if err := client.MkdirAll(target); err != nil {
+						return errors.Wrapf(err, "cannot create remote directory %s", target)
+					}
+				}
+			}
+			dirs.add(name, target, info, pending)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		unchanged, err := opts.unchanged(info, targetInfo,
+			func() (io.ReadCloser, error) { return fsys.Open(name) },
+			func() (io.ReadCloser, error) { return client.Open(target) },
+		)
+		if err != nil {
+			return errors.Wrapf(err, "cannot compare %s", name)
+		}
+		if unchanged {
+			report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionSkip, Size: info.Size()})
+			return nil
+		}
+		if err := dirs.create(name, report, client.MkdirAll); err != nil {
+			return err
+		}
+		report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionCopy, Size: info.Size()})
+		if opts.DryRun {
+			return nil
+		}
+		written, err := s.putDirFile(client, fsys, name, target, info)
+		report.Bytes += written
+		return err
+	})
+	if err != nil {
+		return report, errors.Wrapf(err, "cannot upload to %s", uri.String())
+	}
+	if !opts.DryRun {
+		if err := dirs.apply(client.Chmod, func(p string, mtime time.Time) error { return client.Chtimes(p, mtime, mtime) }); err != nil {
+			return report, err
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func (s *SFTP) putDirFile(client *sftp.Client, fsys fs.FS, name, target string, info fs.FileInfo) (int64, error) {
+	fp, err := fsys.Open(name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot open %s", name)
+	}
+	defer fp.Close()
+	w, err := client.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot create remote file %s", target)
+	}
+	written, err := w.ReadFromWithConcurrency(fp, s.concurrency)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, errors.Wrapf(err, "cannot upload %s", name)
+	}
+	if err := client.Chmod(target, info.Mode().Perm()); err != nil {
+		return written, errors.Wrapf(err, "cannot set permissions of %s", target)
+	}
+	if err := client.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+		return written, errors.Wrapf(err, "cannot set times of %s", target)
+	}
+	return written, nil
+}
+
+// GetDir downloads the directory uri to the local directory target. Modification times and permissions are preserved
+func (s *SFTP) GetDir(uri *url.URL, target string, opts *DirOptions) (*DirReport, error) {
+	opts = opts.withDefaults()
+	sConn, err := s.getSFTPConnection(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer sConn.Release()
+	client, err := sConn.GetSFTPClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create SFTP session")
+	}
+	defer client.Close()
+
+	report := &DirReport{DryRun: opts.DryRun}
+	start := time.Now()
+	var dirs = newDirAttrs()
+	root := path.Clean(uri.Path)
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return report, errors.Wrapf(err, "cannot walk %s", walker.Path())
+		}
+		info := walker.Stat()
+		name := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if name == "" {
+			name = "."
+		}
+		if name != "." && !opts.selected(name, info.IsDir()) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		localPath := filepath.Join(target, filepath.FromSlash(name))
+		localInfo, err := os.Stat(localPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return report, errors.Wrapf(err, "cannot stat %s", localPath)
+		}
+		if info.IsDir() {
+			pending := localInfo == nil && name != "." && len(opts.Include) > 0
+			if localInfo == nil && !pending {
+				report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionMkdir})
+				if !opts.DryRun {
+					if err := os.MkdirAll(localPath, 0777); err != nil {
+						return report, errors.Wrapf(err, "cannot create directory %s", localPath)
+					}
+				}
+			}
+			dirs.add(name, localPath, info, pending)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		remotePath := walker.Path()
+		unchanged, err := opts.unchanged(info, localInfo,
+			func() (io.ReadCloser, error) { return client.Open(remotePath) },
+			func() (io.ReadCloser, error) { return os.Open(localPath) },
+		)
+		if err != nil {
+			return report, errors.Wrapf(err, "cannot compare %s", name)
+		}
+		if unchanged {
+			report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionSkip, Size: info.Size()})
+			continue
+		}
+		if err := dirs.create(name, report, func(p string) error { return os.MkdirAll(p, 0777) }); err != nil {
+			return report, err
+		}
+		report.Entries = append(report.Entries, DirEntryReport{Path: name, Action: DirActionCopy, Size: info.Size()})
+		if opts.DryRun {
+			continue
+		}
+		written, err := getDirFile(client, remotePath, localPath, info)
+		report.Bytes += written
+		if err != nil {
+			return report, err
+		}
+	}
+	if !opts.DryRun {
+		if err := dirs.apply(os.Chmod, func(p string, mtime time.Time) error { return os.Chtimes(p, mtime, mtime) }); err != nil {
+			return report, err
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func getDirFile(client *sftp.Client, remotePath, localPath string, info fs.FileInfo) (int64, error) {
+	r, err := client.Open(remotePath)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot open remote file %s", remotePath)
+	}
+	defer r.Close()
+	w, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot create %s", localPath)
+	}
+	written, err := r.WriteTo(w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, errors.Wrapf(err, "cannot download %s", remotePath)
+	}
+	if err := os.Chmod(localPath, info.Mode().Perm()); err != nil {
+		return written, errors.Wrapf(err, "cannot set permissions of %s", localPath)
+	}
+	if err := os.Chtimes(localPath, info.ModTime(), info.ModTime()); err != nil {
+		return written, errors.Wrapf(err, "cannot set times of %s", localPath)
+	}
+	return written, nil
+}
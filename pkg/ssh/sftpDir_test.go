@@ -0,0 +1,118 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPutGetDir(t *testing.T) {
+	listener := newTestServer(t)
+	s := newTestSFTP(t)
+	source := t.TempDir()
+	remote := t.TempDir()
+	writeTree(t, source, map[string]string{
+		"a.txt":          "a",
+		"sub/b.txt":      "bb",
+		"sub/c.log":      "ccc",
+		"tmp/ignore.txt": "ignore",
+		"logs/d.log":     "dddd",
+		"ro/e.txt":       "eeeee",
+	})
+	// the read-only directory is filled before its permissions are set
+	if err := os.Chmod(filepath.Join(source, "ro"), 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, dir := range []string{source, remote} {
+			os.Chmod(filepath.Join(dir, "ro"), 0755)
+		}
+	})
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(source, "sub/b.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	opts := &DirOptions{Include: []string{"*.txt"}, Exclude: []string{"tmp"}, Sync: SyncSizeModTime}
+
+	report, err := s.PutDir(testURL(listener, remote), os.DirFS(source), &DirOptions{Include: opts.Include, Exclude: opts.Exclude, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(DirActionCopy) != 3 || report.Count(DirActionMkdir) != 2 || report.Bytes != 0 {
+		t.Errorf("invalid dry-run report %+v", report)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "sub")); err == nil {
+		t.Error("dry-run created directory")
+	}
+
+	report, err = s.PutDir(testURL(listener, remote), os.DirFS(source), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(DirActionCopy) != 3 || report.Bytes != 8 {
+		t.Errorf("invalid report %+v", report)
+	}
+	// directories without included files are not created
+	for _, name := range []string{"sub/c.log", "tmp", "logs"} {
+		if _, err := os.Stat(filepath.Join(remote, name)); err == nil {
+			t.Errorf("%s not excluded", name)
+		}
+	}
+	info, err := os.Stat(filepath.Join(remote, "sub/b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) || info.Mode().Perm() != 0640 {
+		t.Errorf("times or permissions not preserved: %v %v", info.ModTime(), info.Mode())
+	}
+	if info, err := os.Stat(filepath.Join(remote, "ro")); err != nil || info.Mode().Perm() != 0555 {
+		t.Errorf("permissions of directory not preserved: %v", err)
+	}
+
+	// unchanged files are skipped
+	writeTree(t, source, map[string]string{"a.txt": "A"})
+	report, err = s.PutDir(testURL(listener, remote), os.DirFS(source), &DirOptions{Include: opts.Include, Exclude: opts.Exclude, Sync: SyncChecksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(DirActionSkip) != 2 || report.Count(DirActionCopy) != 1 || report.Entries[len(report.Entries)-1].Path != "sub/b.txt" {
+		t.Errorf("invalid sync report %+v", report)
+	}
+
+	target := t.TempDir()
+	t.Cleanup(func() { os.Chmod(filepath.Join(target, "ro"), 0755) })
+	report, err = s.GetDir(testURL(listener, remote), target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(DirActionCopy) != 3 || report.Count(DirActionMkdir) != 2 {
+		t.Errorf("invalid download report %+v", report)
+	}
+	if data, err := os.ReadFile(filepath.Join(target, "a.txt")); err != nil || string(data) != "A" {
+		t.Errorf("invalid content %q %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(target, "sub/b.txt")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Errorf("times not preserved: %v", err)
+	}
+	report, err = s.GetDir(testURL(listener, remote), target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(DirActionSkip) != 3 || report.Count(DirActionCopy) != 0 {
+		t.Errorf("invalid download sync report %+v", report)
+	}
+}
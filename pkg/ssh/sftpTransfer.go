@@ -0,0 +1,421 @@
+package ssh
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/je4/utils/v2/pkg/checksum"
+	"github.com/pkg/errors"
+)
+
+// TransferOptions configures UploadFile and DownloadFile
+type TransferOptions struct {
+	// Resume continues a partial transfer. The overlapping tail of the existing target
+	// is compared with the source before the transfer continues behind it
+	Resume bool
+	// VerifySize is the size of the tail which is compared on a sequential resume. Default is 1MiB.
+	// Parallel transfers compare every existing chunk completely
+	VerifySize int64
+	// VerifyDigest is the digest used to compare the tail. Default is sha256
+	VerifyDigest checksum.DigestAlgorithm
+	// Parallel is the number of SFTP sessions for parallel transfers. With less than 2 the file is transferred sequentially
+	Parallel int
+	// ChunkSize is the size of the ranges of parallel transfers. Default is 64MiB
+	ChunkSize int64
+	// Checksums are the digests of the transferred file for the report. Default is sha256
+	Checksums []checksum.DigestAlgorithm
+}
+
+func (opts *TransferOptions) withDefaults() *TransferOptions {
+	var result TransferOptions
+	if opts != nil {
+		result = *opts
+	}
+	if result.VerifySize <= 0 {
+		result.VerifySize = 1024 * 1024
+	}
+	if result.VerifyDigest == "" {
+		result.VerifyDigest = checksum.DigestSHA256
+	}
+	if result.ChunkSize <= 0 {
+		result.ChunkSize = 64 * 1024 * 1024
+	}
+	if len(result.Checksums) == 0 {
+		result.Checksums = []checksum.DigestAlgorithm{checksum.DigestSHA256}
+	}
+	return &result
+}
+
+// TransferReport describes a finished transfer
+type TransferReport struct {
+	Source string
+	Target string
+	// Size is the size of the file
+	Size int64
+	// Bytes is the number of bytes transferred. With resume it is less than Size
+	Bytes int64
+	// Resumed is the offset where a sequential transfer continued
+	Resumed  int64
+	Duration time.Duration
+	Digests  map[checksum.DigestAlgorithm]string
+}
+
+// Rate returns the transferred bytes per second
+func (tr *TransferReport) Rate() float64 {
+	if tr.Duration <= 0 {
+		return 0
+	}
+	return float64(tr.Bytes) / tr.Duration.Seconds()
+}
+
+// getSFTPConnection returns the acquired connection for uri. The caller must Release it
+func (s *SFTP) getSFTPConnection(uri *url.URL) (*SFTPConnection, error) {
+	conn, err := s.AcquireConnection(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to %v with user %v", uri.String(), uri.User.Username())
+	}
+	sConn, err := NewSFTPConnection(conn, s.concurrency, s.maxClientConcurrency, s.maxPacketSize)
+	if err != nil {
+		conn.Release()
+		return nil, errors.Wrapf(err, "unable to create sftp connection for %s", uri.String())
+	}
+	return sConn, nil
+}
+
+// rangeMatches compares the n bytes at offset of source and target
+func rangeMatches(source, target io.ReaderAt, offset, n int64, alg checksum.DigestAlgorithm) (bool, error) {
+	sourceDigest, err := checksum.Checksum(io.NewSectionReader(source, offset, n), alg)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot checksum source")
+	}
+	targetDigest, err := checksum.Checksum(io.NewSectionReader(target, offset, n), alg)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot checksum target")
+	}
+	return sourceDigest == targetDigest, nil
+}
+
+// resumeOffset returns the offset behind the valid part of the target
+func resumeOffset(source, target io.ReaderAt, sourceSize, targetSize int64, opts *TransferOptions) (int64, error) {
+	if !opts.Resume || targetSize <= 0 || targetSize > sourceSize {
+		return 0, nil
+	}
+	n := min(opts.VerifySize, targetSize)
+	ok, err := rangeMatches(source, target, targetSize-n, n, opts.VerifyDigest)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return targetSize, nil
+}
+
+// chunkHandles are the files of a worker of a parallel transfer. existing is the target for reading on resume
+type chunkHandles struct {
+	source   io.ReaderAt
+	target   io.WriterAt
+	existing io.ReaderAt
+	close    func()
+}
+
+// transferChunks copies size bytes in chunks with opts.Parallel workers. On resume, chunks within targetSize
+// which match completely are skipped. It returns the number of bytes copied
+func transferChunks(size, targetSize int64, opts *TransferOptions, open func() (*chunkHandles, error)) (int64, error) {
+	var chunks = make(chan int64)
+	var transferred atomic.Int64
+	var errs = make([]error, opts.Parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Parallel; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			handles, err := open()
+			if err != nil {
+				errs[i] = err
+				// drain the chunks, the transfer fails anyway
+				for range chunks {
+				}
+				return
+			}
+			defer handles.close()
+			for offset := range chunks {
+				if errs[i] != nil {
+					continue
+				}
+				length := min(opts.ChunkSize, size-offset)
+				if opts.Resume && offset+length <= targetSize {
+					ok, err := rangeMatches(handles.source, handles.existing, offset, length, opts.VerifyDigest)
+					if err != nil {
+						errs[i] = errors.Wrapf(err, "cannot verify chunk at %d", offset)
+						continue
+					}
+					if ok {
+						continue
+					}
+				}
+				n, err := io.Copy(io.NewOffsetWriter(handles.target, offset), io.NewSectionReader(handles.source, offset, length))
+				transferred.Add(n)
+				if err != nil {
+					errs[i] = errors.Wrapf(err, "cannot copy chunk at %d", offset)
+				}
+			}
+		}(i)
+	}
+	for offset := int64(0); offset < size; offset += opts.ChunkSize {
+		chunks <- offset
+	}
+	close(chunks)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return transferred.Load(), err
+		}
+	}
+	return transferred.Load(), nil
+}
+
+// UploadFile copies the local file source to uri. It supports resuming a partial upload and parallel
+// uploads over several SFTP sessions of the pooled connection
+func (s *SFTP) UploadFile(uri *url.URL, source string, opts *TransferOptions) (*TransferReport, error) {
+	opts = opts.withDefaults()
+	local, err := os.Open(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open file %s", source)
+	}
+	defer local.Close()
+	stat, err := local.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot stat file %s", source)
+	}
+	report := &TransferReport{Source: source, Target: uri.String(), Size: stat.Size()}
+	start := time.Now()
+
+	sConn, err := s.getSFTPConnection(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer sConn.Release()
+	client, err := sConn.GetSFTPClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create SFTP session")
+	}
+	defer client.Close()
+
+	var remoteSize int64
+	flags := os.O_RDWR | os.O_CREATE
+	if opts.Resume {
+		if remoteStat, err := client.Stat(uri.Path); err == nil {
+			remoteSize = remoteStat.Size()
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	remote, err := client.OpenFile(uri.Path, flags)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open remote file %s", uri.Path)
+	}
+	defer remote.Close()
+
+	if opts.Parallel > 1 {
+		var sourceDigests map[checksum.DigestAlgorithm]string
+		var digestErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sourceDigests, digestErr = checksum.Copy(opts.Checksums, io.NewSectionReader(local, 0, report.Size))
+		}()
+		report.Bytes, err = transferChunks(report.Size, remoteSize, opts, func() (*chunkHandles, error) {
+			workerClient, err := sConn.GetSFTPClient()
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to create SFTP session")
+			}
+			workerRemote, err := workerClient.OpenFile(uri.Path, os.O_RDWR)
+			if err != nil {
+				workerClient.Close()
+				return nil, errors.Wrapf(err, "cannot open remote file %s", uri.Path)
+			}
+			return &chunkHandles{
+				source:   local,
+				target:   workerRemote,
+				existing: workerRemote,
+				close: func() {
+					workerRemote.Close()
+					workerClient.Close()
+				},
+			}, nil
+		})
+		wg.Wait()
+		if err != nil {
+			return report, errors.Wrapf(err, "cannot upload %s", source)
+		}
+		if digestErr != nil {
+			return report, errors.Wrapf(digestErr, "cannot checksum %s", source)
+		}
+		if remoteSize > report.Size {
+			if err := remote.Truncate(report.Size); err != nil {
+				return report, errors.Wrapf(err, "cannot truncate remote file %s", uri.Path)
+			}
+		}
+		// the chunks are written out of order, so the digests are taken from the written file
+		if report.Digests, err = checksum.Copy(opts.Checksums, io.NewSectionReader(remote, 0, report.Size)); err != nil {
+			return report, errors.Wrapf(err, "cannot checksum remote file %s", uri.Path)
+		}
+		for alg, digest := range sourceDigests {
+			if report.Digests[alg] != digest {
+				return report, errors.Errorf("%s checksum of remote file %s does not match %s: %s != %s", alg, uri.Path, source, report.Digests[alg], digest)
+			}
+		}
+	} else {
+		offset, err := resumeOffset(local, remote, report.Size, remoteSize, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot verify remote file %s", uri.Path)
+		}
+		if remoteSize != offset {
+			if err := remote.Truncate(offset); err != nil {
+				return nil, errors.Wrapf(err, "cannot truncate remote file %s", uri.Path)
+			}
+		}
+		if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "cannot seek remote file %s", uri.Path)
+		}
+		cw, err := checksum.NewChecksumWriter(opts.Checksums)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create ChecksumWriter")
+		}
+		if _, err := io.Copy(cw, io.NewSectionReader(local, 0, offset)); err != nil {
+			cw.Close()
+			return nil, errors.Wrapf(err, "cannot checksum %s", source)
+		}
+		report.Resumed = offset
+		report.Bytes, err = remote.ReadFromWithConcurrency(io.TeeReader(io.NewSectionReader(local, offset, report.Size-offset), cw), s.concurrency)
+		if closeErr := cw.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return report, errors.Wrapf(err, "cannot upload %s", source)
+		}
+		if report.Digests, err = cw.GetChecksums(); err != nil {
+			return report, errors.Wrap(err, "cannot get checksums")
+		}
+	}
+	report.Duration = time.Since(start)
+	s.log.Infof("uploaded %s: %dB of %dB in %v (%.2fMB/s)", source, report.Bytes, report.Size, report.Duration, report.Rate()/1000000)
+	return report, nil
+}
+
+// DownloadFile copies uri to the local file target. It supports resuming a partial download and parallel
+// downloads over several SFTP sessions of the pooled connection
+func (s *SFTP) DownloadFile(uri *url.URL, target string, opts *TransferOptions) (*TransferReport, error) {
+	opts = opts.withDefaults()
+	flags := os.O_RDWR | os.O_CREATE
+	if !opts.Resume {
+		flags |= os.O_TRUNC
+	}
+	local, err := os.OpenFile(target, flags, 0666)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open file %s", target)
+	}
+	defer local.Close()
+	localStat, err := local.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot stat file %s", target)
+	}
+	localSize := localStat.Size()
+	start := time.Now()
+
+	sConn, err := s.getSFTPConnection(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer sConn.Release()
+	client, err := sConn.GetSFTPClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create SFTP session")
+	}
+	defer client.Close()
+	remote, err := client.Open(uri.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open remote file %s", uri.Path)
+	}
+	defer remote.Close()
+	stat, err := remote.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot stat remote file %s", uri.Path)
+	}
+	report := &TransferReport{Source: uri.String(), Target: target, Size: stat.Size()}
+
+	if opts.Parallel > 1 {
+		report.Bytes, err = transferChunks(report.Size, localSize, opts, func() (*chunkHandles, error) {
+			workerClient, err := sConn.GetSFTPClient()
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to create SFTP session")
+			}
+			workerRemote, err := workerClient.Open(uri.Path)
+			if err != nil {
+				workerClient.Close()
+				return nil, errors.Wrapf(err, "cannot open remote file %s", uri.Path)
+			}
+			return &chunkHandles{
+				source:   workerRemote,
+				target:   local,
+				existing: local,
+				close: func() {
+					workerRemote.Close()
+					workerClient.Close()
+				},
+			}, nil
+		})
+		if err != nil {
+			return report, errors.Wrapf(err, "cannot download %s", uri.String())
+		}
+		if localSize > report.Size {
+			if err := local.Truncate(report.Size); err != nil {
+				return report, errors.Wrapf(err, "cannot truncate file %s", target)
+			}
+		}
+		if report.Digests, err = checksum.Copy(opts.Checksums, io.NewSectionReader(local, 0, report.Size)); err != nil {
+			return report, errors.Wrapf(err, "cannot checksum %s", target)
+		}
+	} else {
+		offset, err := resumeOffset(remote, local, report.Size, localSize, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot verify file %s", target)
+		}
+		if localSize != offset {
+			if err := local.Truncate(offset); err != nil {
+				return nil, errors.Wrapf(err, "cannot truncate file %s", target)
+			}
+		}
+		if _, err := local.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "cannot seek file %s", target)
+		}
+		if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "cannot seek remote file %s", uri.Path)
+		}
+		cw, err := checksum.NewChecksumWriter(opts.Checksums)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create ChecksumWriter")
+		}
+		if _, err := io.Copy(cw, io.NewSectionReader(local, 0, offset)); err != nil {
+			cw.Close()
+			return nil, errors.Wrapf(err, "cannot checksum %s", target)
+		}
+		report.Resumed = offset
+		report.Bytes, err = remote.WriteTo(io.MultiWriter(local, cw))
+		if closeErr := cw.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return report, errors.Wrapf(err, "cannot download %s", uri.String())
+		}
+		if report.Digests, err = cw.GetChecksums(); err != nil {
+			return report, errors.Wrap(err, "cannot get checksums")
+		}
+	}
+	report.Duration = time.Since(start)
+	s.log.Infof("downloaded %s: %dB of %dB in %v (%.2fMB/s)", uri.String(), report.Bytes, report.Size, report.Duration, report.Rate()/1000000)
+	return report, nil
+}
@@ -0,0 +1,136 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/je4/utils/v2/pkg/checksum"
+)
+
+func TestTransferResume(t *testing.T) {
+	listener := newTestServer(t)
+	s := newTestSFTP(t)
+	dir := t.TempDir()
+
+	var content = make([]byte, 3*1024*1024+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	digest := sha256.Sum256(content)
+	source := filepath.Join(dir, "source.bin")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	remotePath := filepath.Join(dir, "remote.bin")
+
+	// interrupted upload
+	if err := os.WriteFile(remotePath, content[:1024*1024], 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.UploadFile(testURL(listener, remotePath), source, &TransferOptions{Resume: true, VerifySize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != 1024*1024 || report.Bytes != int64(len(content))-1024*1024 || report.Size != int64(len(content)) {
+		t.Errorf("invalid report %+v", report)
+	}
+	if report.Digests[checksum.DigestSHA256] != hex.EncodeToString(digest[:]) {
+		t.Errorf("invalid digest %s", report.Digests[checksum.DigestSHA256])
+	}
+	if data, _ := os.ReadFile(remotePath); !bytes.Equal(data, content) {
+		t.Fatal("invalid remote content after resume")
+	}
+
+	// a modified tail restarts the transfer
+	damaged := bytes.Clone(content[:2*1024*1024])
+	damaged[len(damaged)-1] ^= 0xff
+	if err := os.WriteFile(remotePath, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.UploadFile(testURL(listener, remotePath), source, &TransferOptions{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != 0 || report.Bytes != int64(len(content)) {
+		t.Errorf("invalid report %+v", report)
+	}
+	if data, _ := os.ReadFile(remotePath); !bytes.Equal(data, content) {
+		t.Fatal("invalid remote content after restart")
+	}
+
+	// interrupted download
+	target := filepath.Join(dir, "target.bin")
+	if err := os.WriteFile(target, content[:512*1024], 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.DownloadFile(testURL(listener, remotePath), target, &TransferOptions{Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resumed != 512*1024 || report.Digests[checksum.DigestSHA256] != hex.EncodeToString(digest[:]) {
+		t.Errorf("invalid report %+v", report)
+	}
+	if data, _ := os.ReadFile(target); !bytes.Equal(data, content) {
+		t.Fatal("invalid local content after resume")
+	}
+}
+
+func TestTransferParallel(t *testing.T) {
+	listener := newTestServer(t)
+	s := newTestSFTP(t)
+	dir := t.TempDir()
+
+	var content = make([]byte, 5*1024*1024+77)
+	rand.New(rand.NewSource(2)).Read(content)
+	digest := sha256.Sum256(content)
+	source := filepath.Join(dir, "source.bin")
+	if err := os.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	remotePath := filepath.Join(dir, "remote.bin")
+	opts := &TransferOptions{Parallel: 4, ChunkSize: 1024 * 1024, Resume: true, VerifySize: 1024}
+
+	report, err := s.UploadFile(testURL(listener, remotePath), source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Bytes != int64(len(content)) || report.Digests[checksum.DigestSHA256] != hex.EncodeToString(digest[:]) {
+		t.Errorf("invalid report %+v", report)
+	}
+	if data, _ := os.ReadFile(remotePath); !bytes.Equal(data, content) {
+		t.Fatal("invalid remote content")
+	}
+
+	// only the damaged chunks are transferred again, also if the damage is before the verified tail
+	damaged := bytes.Clone(content)
+	damaged[3*1024*1024-1] ^= 0xff
+	damaged[1024*1024+10] ^= 0xff
+	if err := os.WriteFile(remotePath, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = s.UploadFile(testURL(listener, remotePath), source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Bytes != 2*1024*1024 || report.Digests[checksum.DigestSHA256] != hex.EncodeToString(digest[:]) {
+		t.Errorf("expected two chunks, got %d bytes", report.Bytes)
+	}
+	if data, _ := os.ReadFile(remotePath); !bytes.Equal(data, content) {
+		t.Fatal("invalid remote content after resume")
+	}
+
+	target := filepath.Join(dir, "target.bin")
+	report, err = s.DownloadFile(testURL(listener, remotePath), target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Bytes != int64(len(content)) || report.Digests[checksum.DigestSHA256] != hex.EncodeToString(digest[:]) {
+		t.Errorf("invalid report %+v", report)
+	}
+	if data, _ := os.ReadFile(target); !bytes.Equal(data, content) {
+		t.Fatal("invalid local content")
+	}
+}
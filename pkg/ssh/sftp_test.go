@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const testPassword = "secret"
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newTestServer starts an sftp server with password authentication for user test
+func newTestServer(t *testing.T) net.Listener {
+	t.Helper()
+	return newTestServerWithHostKey(t, newTestSigner(t))
+}
+
+func newTestServerWithHostKey(t *testing.T, hostSigner ssh.Signer) net.Listener {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "test" && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostSigner)
+	return newTestServerWithConfig(t, config)
+}
+
+func newTestServerWithConfig(t *testing.T, config *ssh.ServerConfig) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			nConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(nConn, config)
+		}
+	}()
+	return listener
+}
+
+func serveTestConn(nConn net.Conn, config *ssh.ServerConfig) {
+	sConn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+	if err != nil {
+		nConn.Close()
+		return
+	}
+	defer sConn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go ssh.DiscardRequests(requests)
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+					return
+				}
+			}
+		}()
+	}
+}
+
+func newTestSFTP(t *testing.T) *SFTP {
+	t.Helper()
+	s, err := NewSFTP(nil, testPassword, "", 4, 16, 32*1024, nil, logging.MustGetLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testURL(listener net.Listener, path string) *url.URL {
+	return &url.URL{Scheme: "sftp", User: url.User("test"), Host: listener.Addr().String(), Path: path}
+}
@@ -5,6 +5,9 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Connection struct {
@@ -12,13 +15,25 @@ type Connection struct {
 	config  *ssh.ClientConfig
 	Address *url.URL
 	Log     *logging.Logger
+	// protects Client during reconnect
+	mu       sync.RWMutex
+	alive    atomic.Bool
+	lastUsed atomic.Int64
+	// users is the number of Acquire calls without Release
+	users atomic.Int64
+	// failures is the number of consecutive failed keepalive probes
+	failures atomic.Int32
 }
 
 func NewConnection(address *url.URL, config *ssh.ClientConfig, log *logging.Logger) (*Connection, error) {
+	user := address.User.Username()
+	if user == "" {
+		user = config.User
+	}
 	// create sftpcopy of config with user
 	newConfig := &ssh.ClientConfig{
 		Config:            config.Config,
-		User:              address.User.Username(),
+		User:              user,
 		Auth:              config.Auth,
 		HostKeyCallback:   config.HostKeyCallback,
 		BannerCallback:    config.BannerCallback,
@@ -40,18 +55,99 @@ func NewConnection(address *url.URL, config *ssh.ClientConfig, log *logging.Logg
 	return sc, nil
 }
 
+// Connect dials the server. An existing client is closed
 func (sc *Connection) Connect() error {
-	var err error
-	sc.Client, err = ssh.Dial("tcp", sc.Address.Host, sc.config)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.Client != nil {
+		sc.Client.Close()
+	}
+	client, err := ssh.Dial("tcp", hostPort(sc.Address), sc.config)
 	if err != nil {
+		sc.alive.Store(false)
 		return errors.Wrapf(err, "unable to connect to %v", sc.Address)
 	}
-
+	sc.Client = client
+	sc.alive.Store(true)
+	sc.failures.Store(0)
+	sc.touch()
+	// the connection is dead as soon as the client terminates
+	go func() {
+		client.Wait()
+		if sc.GetClient() == client {
+			sc.alive.Store(false)
+		}
+	}()
 	return nil
 }
 
+// GetClient returns the current ssh client
+func (sc *Connection) GetClient() *ssh.Client {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.Client
+}
+
+// Alive reports whether the client has not terminated
+func (sc *Connection) Alive() bool {
+	return sc.alive.Load()
+}
+
+// KeepAlive sends a keepalive@openssh.com request. Any reply proves the connection to be alive
+func (sc *Connection) KeepAlive(timeout time.Duration) error {
+	client := sc.GetClient()
+	if client == nil || !sc.Alive() {
+		return errors.Errorf("connection to %v is closed", sc.Address)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return errors.Wrapf(err, "keepalive to %v failed", sc.Address)
+		}
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("keepalive to %v timed out after %v", sc.Address, timeout)
+	}
+}
+
+// Acquire marks the connection as in use until Release is called. The pool does not evict connections in use
+func (sc *Connection) Acquire() {
+	sc.users.Add(1)
+	sc.touch()
+}
+
+// Release ends a use started with Acquire
+func (sc *Connection) Release() {
+	sc.touch()
+	sc.users.Add(-1)
+}
+
+// InUse reports whether the connection has been acquired and not released
+func (sc *Connection) InUse() bool {
+	return sc.users.Load() > 0
+}
+
+func (sc *Connection) touch() {
+	sc.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idle returns the time since the last use
+func (sc *Connection) idle() time.Duration {
+	return time.Since(time.Unix(0, sc.lastUsed.Load()))
+}
+
 func (sc *Connection) Close() {
-	sc.Client.Close()
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.alive.Store(false)
+	if sc.Client != nil {
+		sc.Client.Close()
+	}
 }
 
 /*
@@ -1,52 +1,252 @@
 package ssh
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/op/go-logging"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
+	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ErrMaxConnectionsPerHost is returned if a new connection would exceed PoolOptions.MaxPerHost
+var ErrMaxConnectionsPerHost = errors.New("maximum number of connections per host reached")
+
+// PoolOptions configures the health checks and limits of a ConnectionPool
+type PoolOptions struct {
+	// KeepAliveInterval is the interval of keepalive probes. Zero disables them
+	KeepAliveInterval time.Duration
+	// KeepAliveTimeout is the time to wait for the reply of a probe
+	KeepAliveTimeout time.Duration
+	// KeepAliveMaxFailures is the number of consecutive failed probes after which a connection is closed. Zero means 3
+	KeepAliveMaxFailures int
+	// IdleTimeout closes connections which are not in use and have not been requested for this time.
+	// Zero disables it
+	IdleTimeout time.Duration
+	// MaxPerHost limits the connections to the same host and port, e.g. with different users. Zero is unlimited
+	MaxPerHost int
+}
+
+// DefaultPoolOptions returns the options used by NewConnectionPool. Idle connections are kept open
+func DefaultPoolOptions() *PoolOptions {
+	return &PoolOptions{
+		KeepAliveInterval:    30 * time.Second,
+		KeepAliveTimeout:     10 * time.Second,
+		KeepAliveMaxFailures: 3,
+	}
+}
+
 type ConnectionPool struct {
 	// Protects access to fields below
-	mu    sync.Mutex
-	table map[string]*Connection
-	log   *logging.Logger
+	mu     sync.Mutex
+	table  map[string]*Connection
+	log    *logging.Logger
+	opts   *PoolOptions
+	end    chan bool
+	closed bool
 }
 
 func NewConnectionPool(log *logging.Logger) *ConnectionPool {
-	return &ConnectionPool{
+	return NewConnectionPoolWithOptions(DefaultPoolOptions(), log)
+}
+
+// NewConnectionPoolWithOptions creates a pool which checks its connections in the background
+func NewConnectionPoolWithOptions(opts *PoolOptions, log *logging.Logger) *ConnectionPool {
+	cp := &ConnectionPool{
 		mu:    sync.Mutex{},
 		table: map[string]*Connection{},
 		log:   log,
+		opts:  opts,
+		end:   make(chan bool),
+	}
+	interval := opts.KeepAliveInterval
+	if interval <= 0 || (opts.IdleTimeout > 0 && opts.IdleTimeout < interval) {
+		interval = opts.IdleTimeout
+	}
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-cp.end:
+					return
+				case <-ticker.C:
+					cp.check()
+				}
+			}
+		}()
+	}
+	return cp
+}
+
+// hostPort returns the host of address with the default port 22
+func hostPort(address *url.URL) string {
+	port := address.Port()
+	if port == "" {
+		port = "22"
 	}
+	return net.JoinHostPort(address.Hostname(), port)
 }
 
-func (cp *ConnectionPool) GetConnection(address *url.URL, config *ssh.ClientConfig) (*Connection, error) {
+// AuthIdentity returns a pool key component for the public keys and the password used to authenticate
+func AuthIdentity(publicKeys []ssh.PublicKey, password string) string {
+	h := sha256.New()
+	for _, key := range publicKeys {
+		h.Write([]byte(ssh.FingerprintSHA256(key)))
+		h.Write([]byte{0})
+	}
+	if password != "" {
+		pwHash := sha256.Sum256([]byte(password))
+		h.Write(pwHash[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func poolKey(address *url.URL, config *ssh.ClientConfig, identity string) string {
+	user := address.User.Username()
+	if user == "" {
+		user = config.User
+	}
+	return strings.ToLower(fmt.Sprintf("%s@%s#%s", user, hostPort(address), identity))
+}
+
+// GetConnection returns the connection for the user, host, port and auth identity of address.
+// Dead connections are reconnected
+func (cp *ConnectionPool) GetConnection(address *url.URL, config *ssh.ClientConfig, identity string) (*Connection, error) {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
+	return cp.getConnection(address, config, identity)
+}
 
-	id := strings.ToLower(fmt.Sprintf("%s@%s", address.User.Username(), address.Host))
+// AcquireConnection returns the connection like GetConnection and marks it as in use.
+// The caller must call Release on the connection when it is done
+func (cp *ConnectionPool) AcquireConnection(address *url.URL, config *ssh.ClientConfig, identity string) (*Connection, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	conn, err := cp.getConnection(address, config, identity)
+	if err != nil {
+		return nil, err
+	}
+	conn.Acquire()
+	return conn, nil
+}
+
+func (cp *ConnectionPool) getConnection(address *url.URL, config *ssh.ClientConfig, identity string) (*Connection, error) {
+	if cp.closed {
+		return nil, errors.New("connection pool is closed")
+	}
+	switch strings.ToLower(address.Scheme) {
+	case "ssh", "sftp":
+	default:
+		return nil, errors.Errorf("invalid scheme %s in %s", address.Scheme, address.String())
+	}
+	id := poolKey(address, config, identity)
 
 	conn, ok := cp.table[id]
 	if ok {
+		if !conn.Alive() {
+			cp.log.Infof("reconnecting dead %s connection to %v", address.Scheme, id)
+			if err := conn.Connect(); err != nil {
+				delete(cp.table, id)
+				return nil, errors.Wrapf(err, "cannot reconnect ssh connection")
+			}
+		}
+		conn.touch()
 		return conn, nil
 	}
-	var err error
-	switch strings.ToLower(address.Scheme) {
-	case "ssh":
-	case "sftp":
-		cp.log.Infof("new %s connection to %v", address.Scheme, id)
-		conn, err = NewConnection(address, config, cp.log)
-	default:
-		return nil, errors.Wrapf(err, "invalid scheme %s in %s", address.Scheme, address.String())
+	if cp.opts.MaxPerHost > 0 {
+		host := hostPort(address)
+		var count int
+		for key, c := range cp.table {
+			if hostPort(c.Address) != host {
+				continue
+			}
+			if !c.Alive() {
+				// dead connections do not count
+				c.Close()
+				delete(cp.table, key)
+				continue
+			}
+			count++
+		}
+		if count >= cp.opts.MaxPerHost {
+			return nil, errors.Wrapf(ErrMaxConnectionsPerHost, "%d connections to %s", count, host)
+		}
 	}
+	cp.log.Infof("new %s connection to %v", address.Scheme, id)
+	conn, err := NewConnection(address, config, cp.log)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot open ssh connection")
 	}
 	cp.table[id] = conn
 	return conn, nil
 }
+
+// check evicts idle connections and probes the others
+func (cp *ConnectionPool) check() {
+	cp.mu.Lock()
+	var probe = map[string]*Connection{}
+	for key, conn := range cp.table {
+		if cp.opts.IdleTimeout > 0 && !conn.InUse() && conn.idle() > cp.opts.IdleTimeout {
+			cp.log.Infof("closing idle connection to %v", key)
+			conn.Close()
+			delete(cp.table, key)
+			continue
+		}
+		if conn.Alive() {
+			probe[key] = conn
+		}
+	}
+	cp.mu.Unlock()
+	if cp.opts.KeepAliveInterval <= 0 {
+		return
+	}
+	maxFailures := cp.opts.KeepAliveMaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	// probes without lock, a dead connection is reconnected on the next request.
+	// A slow reply may be caused by a busy connection, so a single failure does not close it
+	for key, conn := range probe {
+		if err := conn.KeepAlive(cp.opts.KeepAliveTimeout); err != nil {
+			failures := int(conn.failures.Add(1))
+			if failures < maxFailures {
+				cp.log.Warningf("keepalive to %v failed (%d of %d): %v", key, failures, maxFailures, err)
+				continue
+			}
+			cp.log.Warningf("connection to %v is dead: %v", key, err)
+			conn.Close()
+			continue
+		}
+		conn.failures.Store(0)
+	}
+}
+
+// Len returns the number of pooled connections
+func (cp *ConnectionPool) Len() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.table)
+}
+
+// Close closes all connections and stops the health checks
+func (cp *ConnectionPool) Close() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.closed {
+		return nil
+	}
+	cp.closed = true
+	close(cp.end)
+	for key, conn := range cp.table {
+		conn.Close()
+		delete(cp.table, key)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnectionPool(t *testing.T) {
+	listener := newTestServer(t)
+	other := newTestServer(t)
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.Password(testPassword)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	pool := NewConnectionPoolWithOptions(&PoolOptions{
+		KeepAliveInterval: 20 * time.Millisecond,
+		KeepAliveTimeout:  time.Second,
+		IdleTimeout:       time.Hour,
+		MaxPerHost:        2,
+	}, logging.MustGetLogger("test"))
+	defer pool.Close()
+
+	address := testURL(listener, "/")
+	address.Scheme = "ssh"
+	conn, err := pool.GetConnection(address, config, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := pool.GetConnection(address, config, "a"); same != conn {
+		t.Error("connection not reused")
+	}
+	if _, err := pool.GetConnection(testURL(other, "/"), config, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.GetConnection(address, config, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Len() != 3 {
+		t.Errorf("expected 3 connections for different identities and ports, got %d", pool.Len())
+	}
+	if _, err := pool.GetConnection(address, config, "c"); !errors.Is(err, ErrMaxConnectionsPerHost) {
+		t.Errorf("expected ErrMaxConnectionsPerHost, got %v", err)
+	}
+	if _, err := pool.GetConnection(&url.URL{Scheme: "http", Host: address.Host}, config, "a"); err == nil {
+		t.Error("invalid scheme accepted")
+	}
+
+	// keepalive probes keep the connection alive
+	time.Sleep(100 * time.Millisecond)
+	if !conn.Alive() {
+		t.Fatal("connection died")
+	}
+	// a dead connection is reconnected
+	client := conn.GetClient()
+	client.Close()
+	for i := 0; conn.Alive() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn.Alive() {
+		t.Fatal("dead connection not detected")
+	}
+	reconnected, err := pool.GetConnection(address, config, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconnected != conn || conn.GetClient() == client || !conn.Alive() {
+		t.Error("connection not reconnected")
+	}
+
+	pool.Close()
+	if pool.Len() != 0 || conn.Alive() {
+		t.Error("connections not closed")
+	}
+	if _, err := pool.GetConnection(address, config, "a"); err == nil {
+		t.Error("closed pool returned connection")
+	}
+}
+
+func TestConnectionPoolIdle(t *testing.T) {
+	listener := newTestServer(t)
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.Password(testPassword)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	if DefaultPoolOptions().IdleTimeout != 0 {
+		t.Error("idle timeout enabled by default")
+	}
+	pool := NewConnectionPoolWithOptions(&PoolOptions{IdleTimeout: 30 * time.Millisecond}, logging.MustGetLogger("test"))
+	defer pool.Close()
+	conn, err := pool.AcquireConnection(testURL(listener, "/"), config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connections in use are not evicted
+	time.Sleep(100 * time.Millisecond)
+	if pool.Len() != 1 || !conn.Alive() {
+		t.Fatal("connection in use evicted")
+	}
+	conn.Release()
+	for i := 0; pool.Len() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.Len() != 0 || conn.Alive() {
+		t.Error("idle connection not evicted")
+	}
+}
+
+func TestConnectionPoolKeepAliveFailures(t *testing.T) {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(newTestSigner(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	// the server never answers keepalive probes
+	go func() {
+		for {
+			nConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sConn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+				if err != nil {
+					nConn.Close()
+					return
+				}
+				defer sConn.Close()
+				go func() {
+					for range reqs {
+					}
+				}()
+				for newChannel := range chans {
+					newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+				}
+			}()
+		}
+	}()
+
+	pool := NewConnectionPoolWithOptions(&PoolOptions{
+		KeepAliveInterval:    20 * time.Millisecond,
+		KeepAliveTimeout:     10 * time.Millisecond,
+		KeepAliveMaxFailures: 3,
+	}, logging.MustGetLogger("test"))
+	defer pool.Close()
+	conn, err := pool.GetConnection(testURL(listener, "/"), &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; conn.failures.Load() == 0 && i < 100; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if conn.failures.Load() == 0 {
+		t.Fatal("keepalive not sent")
+	}
+	if !conn.Alive() && conn.failures.Load() < 3 {
+		t.Fatal("connection closed after a single failed probe")
+	}
+	for i := 0; conn.Alive() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn.Alive() {
+		t.Error("connection without keepalive replies not closed")
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/url"
 	"sync"
@@ -38,42 +37,97 @@ type SSHtunnel struct {
 	quit     map[string]chan interface{}
 	listener map[string]net.Listener
 	config   *ssh.ClientConfig
-	client   *ssh.Client
-	log      *logging.Logger
-	wg       sync.WaitGroup
+	// authIdentity and identity distinguish pooled connections like in SFTP
+	authIdentity string
+	identity     string
+	address      *url.URL
+	log          *logging.Logger
+	wg           sync.WaitGroup
 }
 
-func NewSSHTunnel(user, privateKey string, serverEndpoint *Endpoint, tunnels map[string]*SourceDestination, log *logging.Logger) (*SSHtunnel, error) {
-	key, err := ioutil.ReadFile(privateKey)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Unable to read private key %s", privateKey)
+// TunnelOptions configures NewSSHTunnelWithOptions
+type TunnelOptions struct {
+	User string
+	// Auth are the authentication methods
+	Auth *AuthBuilder
+	// HostKeyPolicy verifies the host key of the server. It is required, HostKeyInsecure has to be chosen explicitly
+	HostKeyPolicy *HostKeyPolicy
+	// Pool configures the connection pool. Default is DefaultPoolOptions
+	Pool *PoolOptions
+}
+
+// NewSSHTunnel creates a tunnel to serverEndpoint. privateKey may be empty, if auth is given.
+// The host key is not verified, NewSSHTunnelWithOptions takes a HostKeyPolicy
+func NewSSHTunnel(user, privateKey string, serverEndpoint *Endpoint, tunnels map[string]*SourceDestination, log *logging.Logger, auth ...*AuthBuilder) (*SSHtunnel, error) {
+	builder := NewAuthBuilder()
+	if privateKey != "" {
+		builder.WithPrivateKeyFile(privateKey, nil)
 	}
-	signer, err := ssh.ParsePrivateKey(key)
+	for _, ab := range auth {
+		builder.merge(ab)
+	}
+	return NewSSHTunnelWithOptions(serverEndpoint, tunnels, &TunnelOptions{
+		User:          user,
+		Auth:          builder,
+		HostKeyPolicy: &HostKeyPolicy{Mode: HostKeyInsecure},
+	}, log)
+}
+
+// NewSSHTunnelWithOptions creates a tunnel to serverEndpoint, which verifies the host key with opts.HostKeyPolicy
+func NewSSHTunnelWithOptions(serverEndpoint *Endpoint, tunnels map[string]*SourceDestination, opts *TunnelOptions, log *logging.Logger) (*SSHtunnel, error) {
+	if opts.HostKeyPolicy == nil {
+		return nil, errors.New("no host key policy")
+	}
+	builder := opts.Auth
+	if builder == nil {
+		builder = NewAuthBuilder()
+	}
+	methods, identity, err := builder.Build()
 	if err != nil {
-		return nil, errors.Wrapf(err, "Unable to parse private key")
+		return nil, errors.Wrap(err, "cannot create authentication methods")
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication method")
+	}
+	for _, warning := range builder.Warnings() {
+		log.Warning(warning)
+	}
+	poolOptions := opts.Pool
+	if poolOptions == nil {
+		poolOptions = DefaultPoolOptions()
 	}
 
 	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User: opts.User,
+		Auth: methods,
 	}
 
 	tunnel := &SSHtunnel{
-		config:   sshConfig,
-		server:   serverEndpoint,
-		tunnels:  tunnels,
-		pool:     NewConnectionPool(log),
-		quit:     make(map[string]chan interface{}),
-		listener: make(map[string]net.Listener),
-		log:      log,
+		config:       sshConfig,
+		authIdentity: identity,
+		server:       serverEndpoint,
+		tunnels:      tunnels,
+		quit:         make(map[string]chan interface{}),
+		listener:     make(map[string]net.Listener),
+		log:          log,
 	}
+	if err := tunnel.SetHostKeyPolicy(opts.HostKeyPolicy); err != nil {
+		return nil, err
+	}
+	tunnel.pool = NewConnectionPoolWithOptions(poolOptions, log)
 
 	return tunnel, nil
 }
 
+// SetHostKeyPolicy sets the host key verification. Pooled connections verified with another policy are not reused
+func (tunnel *SSHtunnel) SetHostKeyPolicy(policy *HostKeyPolicy) error {
+	if err := policy.apply(tunnel.config); err != nil {
+		return err
+	}
+	tunnel.identity = tunnel.authIdentity + "/" + policy.identity()
+	return nil
+}
+
 func (tunnel *SSHtunnel) String() string {
 	str := fmt.Sprintf("%v@%v:%v",
 		tunnel.config.User,
@@ -89,7 +143,7 @@ func (tunnel *SSHtunnel) String() string {
 }
 
 func (tunnel *SSHtunnel) Close() {
-	tunnel.client.Close()
+	tunnel.pool.Close()
 	for key, listener := range tunnel.listener {
 		if q, ok := tunnel.quit[key]; ok {
 			close(q)
@@ -104,10 +158,8 @@ func (tunnel *SSHtunnel) Start() error {
 	tunnel.log.Info("starting ssh connection listener")
 
 	tunnel.log.Infof("dialing ssh: %v", tunnel.String())
-	address, _ := url.Parse(fmt.Sprintf("ssh://%s", tunnel.server.String()))
-	tunnel.pool.GetConnection(address, tunnel.config)
-	tunnel.client, err = ssh.Dial("tcp", tunnel.server.String(), tunnel.config)
-	if err != nil {
+	tunnel.address, _ = url.Parse(fmt.Sprintf("ssh://%s", tunnel.server.String()))
+	if _, err := tunnel.pool.GetConnection(tunnel.address, tunnel.config, tunnel.identity); err != nil {
 		return errors.Wrapf(err, "server dial error to %v", tunnel.server.String())
 	}
 
@@ -141,9 +193,14 @@ func (tunnel *SSHtunnel) Start() error {
 }
 
 func (tunnel *SSHtunnel) forward(localConn net.Conn, endpoint *Endpoint) {
-	var err error
-
-	remoteConn, err := tunnel.client.Dial("tcp", endpoint.String())
+	// the pool reconnects dead connections. The connection stays in use as long as the stream is forwarded
+	conn, err := tunnel.pool.AcquireConnection(tunnel.address, tunnel.config, tunnel.identity)
+	if err != nil {
+		tunnel.log.Errorf("cannot get ssh connection to %v: %v", tunnel.server.String(), err)
+		return
+	}
+	defer conn.Release()
+	remoteConn, err := conn.GetClient().Dial("tcp", endpoint.String())
 	if err != nil {
 		tunnel.log.Errorf("Remote dial error %v: %v", endpoint.String(), err)
 		return
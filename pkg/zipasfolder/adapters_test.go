@@ -0,0 +1,175 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func dirNames(t *testing.T, fsys fs.FS, name string) []string {
+	t.Helper()
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestMemFS(t *testing.T) {
+	mfs := NewMemFS()
+	if err := mfs.MkDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.WriteFile("dir/a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, mfs, "b.txt", "b")
+	if err := mfs.WriteFile("missing/c.txt", nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("write to missing directory returned %v", err)
+	}
+	if names := dirNames(t, mfs, "."); !slices.Equal(names, []string{"b.txt", "dir"}) {
+		t.Errorf("invalid entries %v", names)
+	}
+	if err := mfs.Remove("dir"); err == nil {
+		t.Error("non-empty directory removed")
+	}
+	if err := mfs.Rename("dir", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(mfs, "moved/a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("renamed file not readable: %v", err)
+	}
+	if err := mfs.Remove("moved/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("moved/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed file still exists")
+	}
+
+	// zip files within the in-memory filesystem
+	if err := mfs.WriteFile("test.zip", createZIP(t, zip.Deflate, map[string][]byte{"x.txt": []byte("x")})); err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFS(mfs, 5)
+	defer fsys.(FSRWClose).Close()
+	if data, err := fs.ReadFile(fsys, "test.zip/x.txt"); err != nil || string(data) != "x" {
+		t.Errorf("cannot read zip entry in memory: %v", err)
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	rofs := NewReadOnlyFS(fstest.MapFS{"a/b.txt": &fstest.MapFile{Data: []byte("b")}})
+	if data, err := fs.ReadFile(rofs, "a/b.txt"); err != nil || string(data) != "b" {
+		t.Errorf("cannot read file: %v", err)
+	}
+	for name, err := range map[string]error{
+		"create": func() error { _, err := rofs.Create("c.txt"); return err }(),
+		"mkdir":  rofs.MkDir("c"),
+		"remove": rofs.Remove("a/b.txt"),
+		"rename": rofs.Rename("a/b.txt", "c.txt"),
+		"write":  rofs.WriteFile("c.txt", nil),
+	} {
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s returned %v", name, err)
+		}
+	}
+}
+
+func TestOSDirFS(t *testing.T) {
+	outside := t.TempDir()
+	base := filepath.Join(t.TempDir(), "base")
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	ofs, err := NewOSDirFS(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ofs.Close()
+
+	if err := ofs.MkDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ofs.WriteFile("dir/a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ofs.Rename("dir/a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, ofs, "."); !slices.Equal(names, []string{"b.txt", "dir", "escape"}) {
+		t.Errorf("invalid entries %v", names)
+	}
+	for _, name := range []string{"../secret.txt", "dir/../../secret.txt", "escape/secret.txt"} {
+		if _, err := ofs.ReadFile(name); err == nil {
+			t.Errorf("'%s' escaped the base directory", name)
+		}
+		if err := ofs.WriteFile(name, nil); err == nil {
+			t.Errorf("write to '%s' escaped the base directory", name)
+		}
+	}
+	if err := ofs.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSRemoveRename(t *testing.T) {
+	mfs := NewMemFS()
+	if err := mfs.WriteFile("test.zip", createZIP(t, zip.Deflate, map[string][]byte{
+		"keep.txt":    []byte("keep"),
+		"old.txt":     []byte("old"),
+		"dir/a.txt":   []byte("a"),
+		"dir/b/c.txt": []byte("c"),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFS(mfs, 5).(*FS)
+	defer fsys.Close()
+
+	if err := fsys.Remove("test.zip/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Rename("test.zip/old.txt", "test.zip/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Remove("test.zip/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removing missing entry returned %v", err)
+	}
+	if err := fsys.Commit("test.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, fsys, "test.zip"); !slices.Equal(names, []string{"keep.txt", "new.txt"}) {
+		t.Errorf("invalid entries %v", names)
+	}
+	for _, name := range []string{"test.zip/old.txt", "test.zip/dir", "test.zip/dir/b/c.txt"} {
+		if _, err := fsys.Stat(name); err == nil {
+			t.Errorf("'%s' not removed", name)
+		}
+	}
+	if err := fsys.Rename("test.zip", "renamed.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(fsys, "renamed.zip/new.txt"); err != nil || string(data) != "old" {
+		t.Errorf("renamed archive not readable: %v", err)
+	}
+	if err := fsys.Remove("renamed.zip"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("renamed.zip"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("archive not removed")
+	}
+}
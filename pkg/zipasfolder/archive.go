@@ -0,0 +1,202 @@
+package zipasfolder
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"emperror.dev/errors"
+)
+
+// ArchiveFS is the read-only view of an archive within FS
+type ArchiveFS interface {
+	fs.StatFS
+	fs.ReadDirFS
+	// IsLocked reports whether files of the archive are open
+	IsLocked() bool
+	Close() error
+}
+
+// ArchiveFSRaw is implemented by archives which can give direct access to the data of uncompressed entries.
+// It allows nested archives to be read without spooling them to a temporary file.
+type ArchiveFSRaw interface {
+	// RawFile returns the data of name if it is stored uncompressed. ok is false for compressed entries
+	RawFile(name string) (file *ArchiveFile, ok bool, err error)
+}
+
+// ArchiveOpener creates an ArchiveFS from file. The archive takes over the reference to file
+// and releases it on Close, also if an error is returned
+type ArchiveOpener func(file *ArchiveFile) (ArchiveFS, error)
+
+var (
+	archiveOpeners     = map[string]ArchiveOpener{}
+	archiveOpenersLock sync.RWMutex
+)
+
+// RegisterArchiveOpener registers an opener for all files with the given extension (i.e. ".tar.gz").
+// Registering an extension twice returns an error.
+func RegisterArchiveOpener(ext string, opener ArchiveOpener) error {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		return errors.Errorf("invalid extension '%s'", ext)
+	}
+	archiveOpenersLock.Lock()
+	defer archiveOpenersLock.Unlock()
+	if _, ok := archiveOpeners[ext]; ok {
+		return errors.Errorf("opener for '%s' already registered", ext)
+	}
+	archiveOpeners[ext] = opener
+	return nil
+}
+
+// getArchiveOpener returns the opener with the longest extension matching name
+func getArchiveOpener(name string) (ext string, opener ArchiveOpener) {
+	name = strings.ToLower(name)
+	archiveOpenersLock.RLock()
+	defer archiveOpenersLock.RUnlock()
+	for e, o := range archiveOpeners {
+		if strings.HasSuffix(name, e) && len(e) > len(ext) {
+			ext, opener = e, o
+		}
+	}
+	return
+}
+
+func isArchiveFile(name string) bool {
+	_, opener := getArchiveOpener(name)
+	return opener != nil
+}
+
+// ArchiveFile is a reference counted io.ReaderAt. It is shared between an archive, its open files
+// and nested archives which are read directly from it. The underlying file is closed with the last reference.
+type ArchiveFile struct {
+	readerAt io.ReaderAt
+	size     int64
+	closer   func() error
+	refs     atomic.Int64
+}
+
+// NewArchiveFile creates an ArchiveFile with one reference. closer is called when the last reference is released
+func NewArchiveFile(readerAt io.ReaderAt, size int64, closer func() error) *ArchiveFile {
+	af := &ArchiveFile{
+		readerAt: readerAt,
+		size:     size,
+		closer:   closer,
+	}
+	af.refs.Store(1)
+	return af
+}
+
+func (af *ArchiveFile) ReadAt(p []byte, off int64) (int, error) {
+	return af.readerAt.ReadAt(p, off)
+}
+
+func (af *ArchiveFile) Size() int64 {
+	return af.size
+}
+
+// Acquire adds a reference
+func (af *ArchiveFile) Acquire() *ArchiveFile {
+	af.refs.Add(1)
+	return af
+}
+
+// Release removes a reference and closes the file with the last one
+func (af *ArchiveFile) Release() error {
+	refs := af.refs.Add(-1)
+	if refs < 0 {
+		return errors.New("archive file already released")
+	}
+	if refs > 0 || af.closer == nil {
+		return nil
+	}
+	return af.closer()
+}
+
+// Section returns a new ArchiveFile for a part of af, which holds a reference to af
+func (af *ArchiveFile) Section(offset, size int64) *ArchiveFile {
+	af.Acquire()
+	return NewArchiveFile(io.NewSectionReader(af, offset, size), size, af.Release)
+}
+
+// MaxSpoolSize limits the total size of the temporary files of compressed nested archives
+var MaxSpoolSize int64 = 4 << 30
+
+var spoolSize atomic.Int64
+
+// spoolFile copies the data of r to a temporary file, which is removed on release
+func spoolFile(r io.Reader) (*ArchiveFile, error) {
+	tmp, err := os.CreateTemp("", "zipasfolder-spool-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create spool file")
+	}
+	var remove = func() error {
+		tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+	// reserve space chunk by chunk to stay below MaxSpoolSize
+	var size int64
+	var buf = make([]byte, 256*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if spoolSize.Add(int64(n)) > MaxSpoolSize {
+				spoolSize.Add(-(size + int64(n)))
+				remove()
+				return nil, errors.Errorf("spool size limit of %d bytes exceeded", MaxSpoolSize)
+			}
+			size += int64(n)
+			if _, err := tmp.Write(buf[:n]); err != nil {
+				spoolSize.Add(-size)
+				remove()
+				return nil, errors.Wrap(err, "cannot write spool file")
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			spoolSize.Add(-size)
+			remove()
+			return nil, errors.Wrap(err, "cannot read data to spool")
+		}
+	}
+	return NewArchiveFile(tmp, size, func() error {
+		spoolSize.Add(-size)
+		return remove()
+	}), nil
+}
+
+// expandArchive splits name into the path of the innermost archive and the path within it.
+// Nested archives are contained in archivePath (i.e. "outer.zip/inner.tar")
+func expandArchive(name string) (archivePath string, innerPath string, isArchive bool) {
+	name = filepath.ToSlash(filepath.Clean(name))
+	parts := strings.Split(name, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if isArchiveFile(parts[i]) {
+			archivePath = strings.Join(parts[:i+1], "/")
+			innerPath = strings.Join(parts[i+1:], "/")
+			isArchive = true
+			return
+		}
+	}
+	return
+}
+
+// expandParentArchive splits the path of a nested archive into its parent archive and the path within it
+func expandParentArchive(archivePath string) (parentPath string, entryPath string, isNested bool) {
+	dir, file := filepath.Split(filepath.ToSlash(archivePath))
+	if dir == "" {
+		return
+	}
+	parentPath, entryPath, isNested = expandArchive(strings.TrimSuffix(dir, "/"))
+	if !isNested {
+		return
+	}
+	entryPath = strings.TrimPrefix(entryPath+"/"+file, "/")
+	return
+}
@@ -0,0 +1,164 @@
+package zipasfolder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createZIP(t *testing.T, method uint16, files map[string][]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func createTar(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNestedArchives(t *testing.T) {
+	dir := t.TempDir()
+	tarData := createTar(t, map[string][]byte{"dir/tar.txt": []byte("tar content")})
+	gzBuf := &bytes.Buffer{}
+	gw := gzip.NewWriter(gzBuf)
+	gw.Write(tarData)
+	gw.Close()
+	inner := createZIP(t, zip.Deflate, map[string][]byte{"inner.txt": []byte("inner content")})
+	outer := createZIP(t, zip.Store, map[string][]byte{
+		"stored/inner.zip":   inner,
+		"data.tar.gz":        gzBuf.Bytes(),
+		"outer.txt":          []byte("outer content"),
+		"sub/archive.tar":    tarData,
+		"sub/ignore/foo.txt": []byte("foo"),
+	})
+	outerDeflated := createZIP(t, zip.Deflate, map[string][]byte{"inner.zip": inner})
+	for name, data := range map[string][]byte{
+		"outer.zip":    outer,
+		"deflated.zip": outerDeflated,
+		"plain.tar":    tarData,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := NewFS(NewDummyOSRW(dir), 10)
+	defer fsys.(FSRWClose).Close()
+	for name, expected := range map[string]string{
+		"outer.zip/outer.txt":                   "outer content",
+		"outer.zip/stored/inner.zip/inner.txt":  "inner content",
+		"outer.zip/data.tar.gz/dir/tar.txt":     "tar content",
+		"outer.zip/sub/archive.tar/dir/tar.txt": "tar content",
+		"deflated.zip/inner.zip/inner.txt":      "inner content",
+		"plain.tar/dir/tar.txt":                 "tar content",
+	} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("cannot read '%s': %v", name, err)
+			continue
+		}
+		if string(data) != expected {
+			t.Errorf("invalid content of '%s': '%s'", name, data)
+		}
+	}
+	entries, err := fs.ReadDir(fsys, "outer.zip/stored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() || entries[0].Name() != "inner.zip" {
+		t.Errorf("nested archive not shown as folder: %v", entries)
+	}
+	entries, err = fs.ReadDir(fsys, "plain.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() || entries[0].Name() != "dir" {
+		t.Errorf("implicit tar directory missing: %v", entries)
+	}
+	if _, err := fsys.Create("outer.zip/data.tar.gz/new.txt"); err == nil {
+		t.Error("write to nested archive not rejected")
+	}
+}
+
+func TestTarDoubleClose(t *testing.T) {
+	dir := t.TempDir()
+	tarData := createTar(t, map[string][]byte{"a": []byte("entry a"), "b": []byte("entry b")})
+	if err := os.WriteFile(filepath.Join(dir, "x.tar"), tarData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFS(NewDummyOSRW(dir), 10)
+	defer fsys.(FSRWClose).Close()
+	fp, err := fsys.Open("x.tar/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("second close returned %v", err)
+	}
+	// the second close must not release the shared archive
+	data, err := fs.ReadFile(fsys, "x.tar/b")
+	if err != nil {
+		t.Fatalf("cannot read b after double close of a: %v", err)
+	}
+	if string(data) != "entry b" {
+		t.Errorf("invalid content of b: %s", data)
+	}
+}
+
+func TestTarNonRegular(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Linkname: "target", Mode: 0644, Typeflag: tar.TypeSymlink}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archiveFS, err := NewTarFS(NewArchiveFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archiveFS.Close()
+	if _, err := archiveFS.Open("link"); err == nil {
+		t.Error("symlink opened as file")
+	}
+	if _, _, err := archiveFS.(ArchiveFSRaw).RawFile("link"); err == nil {
+		t.Error("raw data of symlink returned")
+	}
+}
@@ -39,3 +39,17 @@ func (d *dummyOSRW) ReadDir(name string) ([]fs.DirEntry, error) {
 func (d *dummyOSRW) ReadFile(name string) ([]byte, error) {
 	return os.ReadFile(filepath.Join(d.dir, name))
 }
+
+func (d *dummyOSRW) Rename(oldPath, newPath string) error {
+	return os.Rename(filepath.Join(d.dir, oldPath), filepath.Join(d.dir, newPath))
+}
+
+func (d *dummyOSRW) Remove(path string) error {
+	return os.Remove(filepath.Join(d.dir, path))
+}
+
+func (d *dummyOSRW) WriteFile(path string, data []byte) error {
+	return os.WriteFile(filepath.Join(d.dir, path), data, 0666)
+}
+
+var _ FSRW = &dummyOSRW{}
@@ -4,19 +4,22 @@ import (
 	"emperror.dev/errors"
 	"io"
 	"io/fs"
+	"sync/atomic"
 )
 
-func NewFile(fileInfo fs.FileInfo, rc io.ReadCloser, lock *Mutex) *File {
+// NewFile creates a file of an archive. release is called once on Close to drop the reference to the archive
+func NewFile(fileInfo fs.FileInfo, rc io.ReadCloser, release func()) *File {
 	return &File{
 		ReadCloser: rc,
-		lock:       lock,
+		release:    release,
 		fileInfo:   fileInfo,
 	}
 }
 
 type File struct {
 	io.ReadCloser
-	lock     *Mutex
+	release  func()
+	closed   atomic.Bool
 	fileInfo fs.FileInfo
 }
 
@@ -24,9 +27,37 @@ func (rcm *File) Stat() (fs.FileInfo, error) {
 	return rcm.fileInfo, nil
 }
 
+// Close closes the reader and releases the archive once. Further calls return fs.ErrClosed
 func (rcm *File) Close() error {
-	defer rcm.lock.Unlock()
+	if rcm.closed.Swap(true) {
+		return fs.ErrClosed
+	}
+	if rcm.release != nil {
+		defer rcm.release()
+	}
 	return errors.WithStack(rcm.ReadCloser.Close())
 }
 
-var _ fs.File = &File{}
+// Seek is supported if the underlying reader is an io.Seeker
+func (rcm *File) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := rcm.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, errors.Errorf("file '%s' is not seekable", rcm.fileInfo.Name())
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// ReadAt is supported if the underlying reader is an io.ReaderAt
+func (rcm *File) ReadAt(p []byte, off int64) (int, error) {
+	readerAt, ok := rcm.ReadCloser.(io.ReaderAt)
+	if !ok {
+		return 0, errors.Errorf("file '%s' does not support random access", rcm.fileInfo.Name())
+	}
+	return readerAt.ReadAt(p, off)
+}
+
+var (
+	_ fs.File     = &File{}
+	_ io.Seeker   = &File{}
+	_ io.ReaderAt = &File{}
+)
@@ -1,101 +1,359 @@
 package zipasfolder
 
 import (
-	"archive/zip"
-	"github.com/bluele/gcache"
-	"github.com/pkg/errors"
 	"io"
 	"io/fs"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"emperror.dev/errors"
+	"github.com/bluele/gcache"
 )
 
 func NewFS(baseFS FSRW, cacheSize int) FSRW {
+	f, err := NewFSWithOptions(baseFS, DefaultOptions(cacheSize))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// NewFSWithOptions creates an FS with a configured archive cache
+func NewFSWithOptions(baseFS FSRW, opts *Options) (*FS, error) {
+	if err := opts.check(); err != nil {
+		return nil, errors.Wrap(err, "invalid options")
+	}
 	f := &FS{
-		baseFS: baseFS,
-		zipCache: gcache.New(cacheSize).
-			LRU().
-			LoaderFunc(func(key interface{}) (interface{}, error) {
-				zipFilename, ok := key.(string)
-				if !ok {
-					return nil, errors.Errorf("cannot cast key %v to string", key)
-				}
-				zipFile, err := baseFS.Open(zipFilename)
-				if err != nil {
-					return nil, errors.Wrapf(err, "cannot open zip file '%s'", zipFilename)
-				}
-				stat, err := zipFile.Stat()
-				if err != nil {
-					return nil, errors.Wrapf(err, "cannot stat zip file '%s'", zipFilename)
-				}
-				filesize := stat.Size()
-				readerAt, ok := zipFile.(io.ReaderAt)
-				if !ok {
-					zipFile.Close()
-					return nil, errors.Errorf("cannot cast file '%s' to io.ReaderAt", zipFilename)
-				}
-				zipReader, err := zip.NewReader(readerAt, filesize)
-				if err != nil {
-					zipFile.Close()
-					return nil, errors.Wrapf(err, "cannot create zip reader for '%s'", zipFilename)
-				}
-				zipFS := NewZIPFS(zipReader, zipFile)
-				return zipFS, nil
-			}).
-			EvictedFunc(func(key, value any) {
-				zipFS, ok := value.(*ZIPFS)
-				if !ok {
-					return
-				}
-				zipFS.Close()
-			}).
-			PurgeVisitorFunc(func(key, value any) {
-				zipFS, ok := value.(*ZIPFS)
-				if !ok {
-					return
-				}
-				zipFS.Close()
-			}).
-			Build(),
-		end: make(chan bool),
+		baseFS:      baseFS,
+		opts:        opts,
+		end:         make(chan bool),
+		stages:      map[string]*zipStage{},
+		commitLocks: map[string]*commitLock{},
+		removing:    map[any]*atomic.Uint64{},
+		lastAccess:  map[string]time.Time{},
+		openFiles: &openFiles{
+			files:  map[*trackedFile]*openFile{},
+			record: opts.LeakThreshold > 0,
+		},
 	}
+	f.zipCache = gcache.New(opts.CacheSize).
+		EvictType(string(opts.Policy)).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			archivePath, ok := key.(string)
+			if !ok {
+				return nil, errors.Errorf("cannot cast key %v to string", key)
+			}
+			archiveFS, err := f.loadArchive(archivePath)
+			if err != nil {
+				return nil, err
+			}
+			return newCachedArchive(archiveFS), nil
+		}).
+		EvictedFunc(func(key, value any) {
+			f.countRemoval(key)
+			f.forget(key)
+			archive, ok := value.(*cachedArchive)
+			if !ok {
+				return
+			}
+			// users and open files keep the archive alive until they are done
+			archive.release()
+		}).
+		PurgeVisitorFunc(func(key, value any) {
+			f.forget(key)
+			archive, ok := value.(*cachedArchive)
+			if !ok {
+				return
+			}
+			archive.release()
+		}).
+		Build()
 	go func() {
-		for alive := true; alive; {
-			timer := time.NewTimer(time.Minute)
+		ticker := time.NewTicker(opts.SweepInterval)
+		defer ticker.Stop()
+		for {
 			select {
 			case <-f.end:
-				timer.Stop()
-				alive = false
-			case <-timer.C:
-				f.ClearUnlocked()
+				return
+			case <-ticker.C:
+				f.sweep()
 			}
 		}
 	}()
-	return f
+	return f, nil
 }
 
 type FS struct {
-	baseFS   FSRW
-	zipCache gcache.Cache
-	lock     sync.RWMutex
-	end      chan bool
+	baseFS    FSRW
+	opts      *Options
+	zipCache  gcache.Cache
+	lock      sync.RWMutex
+	end       chan bool
+	stages    map[string]*zipStage
+	stageLock sync.Mutex
+	// commitLocks serialize the rebuild of each archive
+	commitLocks map[string]*commitLock
+	closed      bool
+	lastAccess  map[string]time.Time
+	accessLock  sync.Mutex
+	evictions   atomic.Uint64
+	expired     atomic.Uint64
+	removed     atomic.Uint64
+	// removing maps the keys removed explicitly to their counter
+	removing     map[any]*atomic.Uint64
+	removingLock sync.Mutex
+	openFiles    *openFiles
+}
+
+// loadArchive opens an archive from the base filesystem or from its parent archive
+func (fsys *FS) loadArchive(archivePath string) (ArchiveFS, error) {
+	_, opener := getArchiveOpener(archivePath)
+	if opener == nil {
+		return nil, errors.Errorf("no opener for archive '%s'", archivePath)
+	}
+	var file *ArchiveFile
+	parentPath, entryPath, isNested := expandParentArchive(archivePath)
+	if isNested {
+		parent, release, err := fsys.getArchive(parentPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get parent archive '%s'", parentPath)
+		}
+		file, err = openNestedArchiveFile(parent, entryPath)
+		release()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open '%s' in archive '%s'", entryPath, parentPath)
+		}
+	} else {
+		baseFile, err := fsys.baseFS.Open(archivePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open archive '%s'", archivePath)
+		}
+		stat, err := baseFile.Stat()
+		if err != nil {
+			baseFile.Close()
+			return nil, errors.Wrapf(err, "cannot stat archive '%s'", archivePath)
+		}
+		readerAt, ok := baseFile.(io.ReaderAt)
+		if !ok {
+			baseFile.Close()
+			return nil, errors.Errorf("cannot cast file '%s' to io.ReaderAt", archivePath)
+		}
+		file = NewArchiveFile(readerAt, stat.Size(), baseFile.Close)
+	}
+	archiveFS, err := opener(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open archive '%s'", archivePath)
+	}
+	if zipFS, ok := archiveFS.(*ZIPFS); ok && fsys.opts.PasswordFunc != nil {
+		zipFS.SetPasswordFunc(func(name string) (string, error) {
+			return fsys.opts.PasswordFunc(archivePath, name)
+		})
+	}
+	return archiveFS, nil
 }
 
+// openNestedArchiveFile reads uncompressed entries directly from the parent and spools compressed ones
+func openNestedArchiveFile(parent ArchiveFS, entryPath string) (*ArchiveFile, error) {
+	if raw, ok := parent.(ArchiveFSRaw); ok {
+		file, ok, err := raw.RawFile(entryPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return file, nil
+		}
+	}
+	rc, err := parent.Open(entryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return spoolFile(rc)
+}
+
+// cachedArchive counts the users of a cached archive. The cache holds one reference,
+// the archive is closed with the last one
+type cachedArchive struct {
+	ArchiveFS
+	refs atomic.Int64
+}
+
+func newCachedArchive(archiveFS ArchiveFS) *cachedArchive {
+	archive := &cachedArchive{ArchiveFS: archiveFS}
+	archive.refs.Store(1)
+	return archive
+}
+
+// acquire adds a reference unless the archive is closed already
+func (ca *cachedArchive) acquire() bool {
+	for {
+		refs := ca.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if ca.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release removes a reference and closes the archive with the last one
+func (ca *cachedArchive) release() error {
+	if ca.refs.Add(-1) != 0 {
+		return nil
+	}
+	return ca.ArchiveFS.Close()
+}
+
+// IsLocked reports whether the archive is in use or has open files
+func (ca *cachedArchive) IsLocked() bool {
+	return ca.refs.Load() > 1 || ca.ArchiveFS.IsLocked()
+}
+
+// getArchive returns a cached archive. It stays open until release is called, even if it is evicted meanwhile
+func (fsys *FS) getArchive(archivePath string) (ArchiveFS, func(), error) {
+	fsys.accessLock.Lock()
+	fsys.lastAccess[archivePath] = time.Now()
+	fsys.accessLock.Unlock()
+	for {
+		archiveCache, err := fsys.zipCache.Get(archivePath)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot get archive '%s'", archivePath)
+		}
+		archive, ok := archiveCache.(*cachedArchive)
+		if !ok {
+			return nil, nil, errors.Errorf("cannot cast archive '%s' to ArchiveFS", archivePath)
+		}
+		if archive.acquire() {
+			return archive.ArchiveFS, func() { archive.release() }, nil
+		}
+		// evicted and closed after Get, the next Get loads it again
+	}
+}
+
+// Create creates a file. Files within zip files are staged and written on Commit or Close
 func (fsys *FS) Create(path string) (FileW, error) {
+	path = strings.TrimPrefix(path, "./")
+	path = strings.Trim(path, "/")
+	archivePath, innerPath, isArchive := expandArchive(path)
+	if isArchive {
+		if err := checkWritableArchive(archivePath); err != nil {
+			return nil, err
+		}
+		return fsys.createZIPEntry(archivePath, innerPath)
+	}
 	return fsys.baseFS.Create(path)
 }
 
+// MkDir creates a directory. Directories within zip files are staged and written on Commit or Close
 func (fsys *FS) MkDir(path string) error {
+	path = strings.TrimPrefix(path, "./")
+	path = strings.Trim(path, "/")
+	archivePath, innerPath, isArchive := expandArchive(path)
+	if isArchive {
+		if err := checkWritableArchive(archivePath); err != nil {
+			return err
+		}
+		return fsys.mkDirZIPEntry(archivePath, innerPath)
+	}
 	return fsys.baseFS.MkDir(path)
 }
 
+// Remove removes a file or an empty directory. Removing entries of zip files is staged and
+// written on Commit or Close, removing a directory within a zip file removes all entries below it
+func (fsys *FS) Remove(path string) error {
+	path = strings.TrimPrefix(path, "./")
+	path = strings.Trim(path, "/")
+	archivePath, innerPath, isArchive := expandArchive(path)
+	if !isArchive {
+		return fsys.baseFS.Remove(path)
+	}
+	if innerPath == "" {
+		if _, _, isNested := expandParentArchive(archivePath); !isNested {
+			return fsys.removeZIPFile(archivePath)
+		}
+	}
+	if err := checkWritableArchive(archivePath); err != nil {
+		return err
+	}
+	return fsys.removeZIPEntry(archivePath, innerPath)
+}
+
+// isBasePath checks whether path is not within an archive
+func isBasePath(path string) bool {
+	archivePath, innerPath, isArchive := expandArchive(path)
+	if !isArchive {
+		return true
+	}
+	if innerPath != "" {
+		return false
+	}
+	_, _, isNested := expandParentArchive(archivePath)
+	return !isNested
+}
+
+// Rename renames a file. Staged zip files are committed before they or their folder are renamed.
+// Files within zip files are copied to the new name and removed
+func (fsys *FS) Rename(oldPath, newPath string) error {
+	oldPath = strings.Trim(strings.TrimPrefix(oldPath, "./"), "/")
+	newPath = strings.Trim(strings.TrimPrefix(newPath, "./"), "/")
+	if isBasePath(oldPath) && isBasePath(newPath) {
+		if err := fsys.commitBelow(oldPath); err != nil {
+			return errors.Wrapf(err, "cannot commit '%s'", oldPath)
+		}
+		fsys.Release(oldPath)
+		return fsys.baseFS.Rename(oldPath, newPath)
+	}
+	info, err := fsys.Stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.Errorf("cannot rename directory '%s' within archive", oldPath)
+	}
+	src, err := fsys.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := fsys.Create(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return errors.Wrapf(err, "cannot copy '%s' to '%s'", oldPath, newPath)
+	}
+	if err := dst.Close(); err != nil {
+		return errors.Wrapf(err, "cannot close '%s'", newPath)
+	}
+	return fsys.Remove(oldPath)
+}
+
+// WriteFile writes data to path. Files within zip files are staged and written on Commit or Close
+func (fsys *FS) WriteFile(path string, data []byte) error {
+	return writeFileByCreate(fsys, path, data)
+}
+
+// checkWritableArchive allows writing only to zip files which are not nested
+func checkWritableArchive(archivePath string) error {
+	if ext, _ := getArchiveOpener(archivePath); ext != ".zip" {
+		return errors.Wrapf(fs.ErrPermission, "archive '%s' is read-only", archivePath)
+	}
+	if _, _, isNested := expandParentArchive(archivePath); isNested {
+		return errors.Wrapf(fs.ErrPermission, "nested archive '%s' is read-only", archivePath)
+	}
+	return nil
+}
+
 func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 	name = strings.TrimPrefix(name, "./")
 	name = strings.Trim(name, "/")
-	zipFile, zipPath, isZIP := expandZipFile(name)
-	if !isZIP {
+	archivePath, innerPath, isArchive := expandArchive(name)
+	if !isArchive {
 		info, err := fsys.baseFS.Stat(name)
 		if err != nil {
 			return info, errors.Wrapf(err, "cannot open file '%s'", name)
@@ -104,16 +362,15 @@ func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 	}
 	fsys.lock.RLock()
 	defer fsys.lock.RUnlock()
-	zipFSCache, err := fsys.zipCache.Get(zipFile)
+	archiveFS, release, err := fsys.getArchive(archivePath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot get zip file '%s'", zipFile)
+		return nil, err
 	}
-	zipFS, ok := zipFSCache.(*ZIPFS)
-	if !ok {
-		return nil, errors.Errorf("cannot cast zip file '%s' to *ZIPFS", zipFile)
+	defer release()
+	if innerPath == "" {
+		return NewZIPFSFileInfoDir(path.Base(archivePath)), nil
 	}
-	return zipFS.Stat(zipPath)
-
+	return archiveFS.Stat(innerPath)
 }
 
 func (fsys *FS) Sub(dir string) (FSRW, error) {
@@ -132,8 +389,8 @@ func (fsys *FS) ReadFile(name string) ([]byte, error) {
 func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	name = strings.TrimPrefix(name, "./")
 	name = strings.Trim(name, "/")
-	zipFile, zipPath, isZIP := expandZipFile(name)
-	if !isZIP {
+	archivePath, innerPath, isArchive := expandArchive(name)
+	if !isArchive {
 		if name == "" {
 			name = "."
 		}
@@ -148,7 +405,7 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 			if err != nil {
 				return nil, errors.Wrapf(err, "cannot get info for file '%s'", entry.Name())
 			}
-			if fi.IsDir() || isZipFile(entry.Name()) {
+			if fi.IsDir() || isArchiveFile(entry.Name()) {
 				result = append(result, NewZIPFSDirEntry(NewZIPFSFileInfoDir(entry.Name())))
 			} else {
 				result = append(result, NewZIPFSDirEntry(fi))
@@ -158,22 +415,29 @@ func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
 	}
 	fsys.lock.RLock()
 	defer fsys.lock.RUnlock()
-	zipFSCache, err := fsys.zipCache.Get(zipFile)
+	archiveFS, release, err := fsys.getArchive(archivePath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot get zip file '%s'", zipFile)
+		return nil, err
 	}
-	zipFS, ok := zipFSCache.(*ZIPFS)
-	if !ok {
-		return nil, errors.Errorf("cannot cast zip file '%s' to *ZIPFS", zipFile)
+	defer release()
+	entries, err := archiveFS.ReadDir(innerPath)
+	if err != nil {
+		return nil, err
 	}
-	return zipFS.ReadDir(zipPath)
+	// nested archives are shown as folders
+	for i, entry := range entries {
+		if !entry.IsDir() && isArchiveFile(entry.Name()) {
+			entries[i] = NewZIPFSDirEntry(NewZIPFSFileInfoDir(entry.Name()))
+		}
+	}
+	return entries, nil
 }
 
 func (fsys *FS) Open(name string) (fs.File, error) {
 	name = strings.TrimPrefix(name, "./")
 	name = strings.Trim(name, "/")
-	zipFile, zipPath, isZIP := expandZipFile(name)
-	if !isZIP {
+	archivePath, innerPath, isArchive := expandArchive(name)
+	if !isArchive {
 		file, err := fsys.baseFS.Open(name)
 		if err != nil {
 			return file, errors.Wrapf(err, "cannot open file '%s'", name)
@@ -183,60 +447,129 @@ func (fsys *FS) Open(name string) (fs.File, error) {
 
 	fsys.lock.RLock()
 	defer fsys.lock.RUnlock()
-	zipFSCache, err := fsys.zipCache.Get(zipFile)
+	archiveFS, release, err := fsys.getArchive(archivePath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot get zip file '%s'", zipFile)
+		return nil, err
 	}
-	zipFS, ok := zipFSCache.(*ZIPFS)
-	if !ok {
-		return nil, errors.Errorf("cannot cast zip file '%s' to *ZIPFS", zipFile)
-	}
-	rc, err := zipFS.Open(zipPath)
+	// open files hold their own reference to the archive data
+	defer release()
+	rc, err := archiveFS.Open(innerPath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot open file '%s' in zip file '%s'", zipPath, zipFile)
+		return nil, errors.Wrapf(err, "cannot open file '%s' in archive '%s'", innerPath, archivePath)
 	}
-	return rc, nil
+	return fsys.openFiles.track(archivePath, innerPath, rc), nil
 }
 
+// Close commits all staged zip files and closes all cached archives
 func (fsys *FS) Close() error {
+	err := fsys.commitAll()
 	fsys.lock.Lock()
 	defer fsys.lock.Unlock()
-	fsys.end <- true
+	if fsys.closed {
+		return err
+	}
+	fsys.closed = true
+	close(fsys.end)
 	fsys.zipCache.Purge()
-	return nil
+	return err
 }
 
-func (fsys *FS) ClearUnlocked() error {
+// forget removes the access time of an archive which is not cached anymore
+func (fsys *FS) forget(key any) {
+	archivePath, ok := key.(string)
+	if !ok {
+		return
+	}
+	fsys.accessLock.Lock()
+	delete(fsys.lastAccess, archivePath)
+	fsys.accessLock.Unlock()
+}
+
+// sweep closes idle archives and reports leaks
+func (fsys *FS) sweep() {
+	fsys.clearIdle(fsys.opts.IdleTimeout, &fsys.expired)
+	if fsys.opts.LeakThreshold > 0 && fsys.opts.LeakFunc != nil {
+		for _, leak := range fsys.openFiles.leaks(fsys.opts.LeakThreshold, true) {
+			fsys.opts.LeakFunc(leak)
+		}
+	}
+}
+
+// clearIdle removes all archives without open files which were not used for idle
+func (fsys *FS) clearIdle(idle time.Duration, counter *atomic.Uint64) {
 	fsys.lock.Lock()
 	defer fsys.lock.Unlock()
-	fss := fsys.zipCache.GetALL(false)
-	for key, fs := range fss {
-		fs, ok := fs.(*ZIPFS)
-		if !ok {
+	for key, value := range fsys.zipCache.GetALL(false) {
+		archive, ok := value.(*cachedArchive)
+		if !ok || archive.IsLocked() {
 			continue
 		}
-		if !fs.IsLocked() {
-			fsys.zipCache.Remove(key)
+		archivePath, _ := key.(string)
+		fsys.accessLock.Lock()
+		lastAccess := fsys.lastAccess[archivePath]
+		fsys.accessLock.Unlock()
+		if time.Since(lastAccess) >= idle {
+			fsys.removeCached(key, counter)
 		}
 	}
-	return nil
 }
-func isZipFile(name string) bool {
-	return strings.ToLower(filepath.Ext(name)) == ".zip"
+
+// removeCached removes key from the cache and counts it with counter instead of the evictions
+func (fsys *FS) removeCached(key any, counter *atomic.Uint64) {
+	fsys.removingLock.Lock()
+	fsys.removing[key] = counter
+	fsys.removingLock.Unlock()
+	fsys.zipCache.Remove(key)
+	fsys.removingLock.Lock()
+	delete(fsys.removing, key)
+	fsys.removingLock.Unlock()
+}
+
+// countRemoval counts an archive dropped by the cache. Keys not removed explicitly are evicted by capacity
+func (fsys *FS) countRemoval(key any) {
+	fsys.removingLock.Lock()
+	counter, ok := fsys.removing[key]
+	fsys.removingLock.Unlock()
+	if !ok {
+		counter = &fsys.evictions
+	}
+	counter.Add(1)
 }
 
-func expandZipFile(name string) (zipFile string, zipPath string, isZip bool) {
-	name = filepath.ToSlash(filepath.Clean(name))
-	parts := strings.Split(name, "/")
-	for i := len(parts) - 1; i >= 0; i-- {
-		if isZipFile(parts[i]) {
-			zipFile = strings.Join(parts[:i+1], "/")
-			zipPath = strings.Join(parts[i+1:], "/")
-			isZip = true
-			return
+// Release closes the archive and all its nested archives. Files which are still open keep them readable until they are closed
+func (fsys *FS) Release(archivePath string) {
+	archivePath = strings.Trim(archivePath, "/")
+	fsys.lock.Lock()
+	defer fsys.lock.Unlock()
+	for _, key := range fsys.zipCache.Keys(false) {
+		if name, ok := key.(string); ok && (name == archivePath || strings.HasPrefix(name, archivePath+"/")) {
+			fsys.removeCached(key, &fsys.removed)
 		}
 	}
-	return
+}
+
+// CacheStats returns the counters of the archive cache
+func (fsys *FS) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:      fsys.zipCache.HitCount(),
+		Misses:    fsys.zipCache.MissCount(),
+		Evictions: fsys.evictions.Load(),
+		Expired:   fsys.expired.Load(),
+		Removed:   fsys.removed.Load(),
+		Archives:  fsys.zipCache.Len(false),
+		OpenFiles: fsys.openFiles.count(),
+	}
+}
+
+// Leaks returns all files open longer than the leak threshold, or all open files if no threshold is configured
+func (fsys *FS) Leaks() []Leak {
+	return fsys.openFiles.leaks(fsys.opts.LeakThreshold, false)
+}
+
+// ClearUnlocked removes all archives without open files from the cache
+func (fsys *FS) ClearUnlocked() error {
+	fsys.clearIdle(0, &fsys.removed)
+	return nil
 }
 
 var (
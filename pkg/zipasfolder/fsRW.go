@@ -3,18 +3,42 @@ package zipasfolder
 import (
 	"io"
 	"io/fs"
+
+	"emperror.dev/errors"
 )
 
 type FileW interface {
 	io.WriteCloser
 }
 
+// FileWAbort is implemented by writers which can discard their data instead of storing it on Close
+type FileWAbort interface {
+	FileW
+	Abort() error
+}
+
 type FSRW interface {
 	fs.StatFS
 	Create(path string) (FileW, error)
 	MkDir(path string) error
+	Remove(path string) error
+	Rename(oldPath, newPath string) error
+	WriteFile(path string, data []byte) error
 }
 
 type FSRWClose interface {
 	Close() error
 }
+
+// writeFileByCreate implements WriteFile with Create
+func writeFileByCreate(fsys FSRW, path string, data []byte) error {
+	fp, err := fsys.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create '%s'", path)
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return errors.Wrapf(err, "cannot write '%s'", path)
+	}
+	return errors.Wrapf(fp.Close(), "cannot close '%s'", path)
+}
@@ -0,0 +1,134 @@
+package zipasfolder
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, fsys FSRW, name, data string) {
+	t.Helper()
+	w, err := fsys.Create(name)
+	if err != nil {
+		t.Fatalf("cannot create '%s': %v", name, err)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatalf("cannot write '%s': %v", name, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("cannot close '%s': %v", name, err)
+	}
+}
+
+func TestFSWriteZIP(t *testing.T) {
+	dir := t.TempDir()
+	fsys := NewFS(NewDummyOSRW(dir), 5).(*FS)
+	defer fsys.Close()
+
+	writeFile(t, fsys, "archive.zip/dir/file.txt", "Hello World!")
+	if err := fsys.MkDir("archive.zip/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("archive.zip/dir/file.txt"); err == nil {
+		t.Error("staged entry visible before commit")
+	}
+	if err := fsys.Commit("archive.zip"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(fsys, "archive.zip/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello World!" {
+		t.Errorf("invalid content '%s'", data)
+	}
+
+	// an open reader keeps its snapshot during the commit
+	fp, err := fsys.Open("archive.zip/dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fsys, "archive.zip/dir/file.txt", "Hello Universe!")
+	writeFile(t, fsys, "archive.zip/other.txt", "other")
+	done := make(chan error)
+	go func() {
+		done <- fsys.Commit("archive.zip")
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello World!" {
+		t.Errorf("invalid snapshot content '%s'", data)
+	}
+	fp.Close()
+
+	for name, expected := range map[string]string{
+		"archive.zip/dir/file.txt": "Hello Universe!",
+		"archive.zip/other.txt":    "other",
+	} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("invalid content of '%s': '%s'", name, data)
+		}
+	}
+	writeFile(t, fsys, "archive.zip/late.txt", "late")
+	if err := fsys.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fsys2 := NewFS(NewDummyOSRW(dir), 5)
+	defer fsys2.(FSRWClose).Close()
+	if _, err := fsys2.Stat("archive.zip/late.txt"); err != nil {
+		t.Errorf("entry not committed on close: %v", err)
+	}
+}
+
+func TestFSCommitPerArchive(t *testing.T) {
+	dir := t.TempDir()
+	fsys := NewFS(NewDummyOSRW(dir), 5).(*FS)
+	defer fsys.Close()
+
+	// a long running commit of a.zip holds its archive lock
+	unlock := fsys.lockArchive("a.zip")
+	// staging is not blocked by the archive lock
+	writeFile(t, fsys, "a.zip/file.txt", "a")
+	writeFile(t, fsys, "b.zip/file.txt", "b")
+	done := make(chan error, 1)
+	go func() {
+		done <- fsys.Commit("b.zip")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("commit of b.zip blocked by a.zip")
+	}
+	unlock()
+	if err := fsys.Commit("a.zip"); err != nil {
+		t.Fatal(err)
+	}
+	// the locks are removed with their last user
+	fsys.stageLock.Lock()
+	if len(fsys.commitLocks) != 0 {
+		t.Errorf("%d commit locks left", len(fsys.commitLocks))
+	}
+	fsys.stageLock.Unlock()
+	for name, expected := range map[string]string{"a.zip/file.txt": "a", "b.zip/file.txt": "b"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("invalid content of '%s': %s", name, data)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package httpfs
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/mimetypes"
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+)
+
+var mimeExt = sync.OnceValue(mimetypes.NewMimeExt)
+
+// ContentType returns the content type of name based on its extension
+func ContentType(name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if ext == "" {
+		return "application/octet-stream"
+	}
+	// the order of the types of an extension is not stable
+	return slices.Min(mimeExt().GetMime(ext))
+}
+
+// cleanBasePath returns basePath with a leading and without a trailing slash. The root is the empty string
+func cleanBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// NewHandler creates a read-only http.Handler for fsys, which is served below basePath.
+// Directories (including archives) are shown as html listings, files support range requests.
+func NewHandler(fsys zipasfolder.FSRW, basePath string) *Handler {
+	return &Handler{
+		fsys:     fsys,
+		basePath: cleanBasePath(basePath),
+	}
+}
+
+type Handler struct {
+	fsys     zipasfolder.FSRW
+	basePath string
+}
+
+// errorStatus maps filesystem errors to http status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := r.URL.Path
+	if urlPath == h.basePath {
+		http.Redirect(w, r, h.basePath+"/", http.StatusMovedPermanently)
+		return
+	}
+	if !strings.HasPrefix(urlPath, h.basePath+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.Trim(path.Clean(strings.TrimPrefix(urlPath, h.basePath)), "/")
+	info, err := h.fsys.Stat(name)
+	if err != nil {
+		status := errorStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			http.Redirect(w, r, path.Base(urlPath)+"/", http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, name)
+		return
+	}
+	h.serveFile(w, r, name, info)
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	fp, err := h.fsys.Open(name)
+	if err != nil {
+		status := errorStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer fp.Close()
+	w.Header().Set("Content-Type", ContentType(name))
+	if rs, ok := fp.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(name), info.ModTime(), rs)
+		return
+	}
+	// no range support without io.Seeker
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if !info.ModTime().IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, fp)
+}
+
+type listEntry struct {
+	Name    string
+	URL     string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+var listTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if .Parent}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{if not .ModTime.IsZero}}{{.ModTime.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(h.fsys, name)
+	if err != nil {
+		status := errorStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	var list = make([]listEntry, 0, len(entries))
+	for _, entry := range entries {
+		le := listEntry{
+			Name:  entry.Name(),
+			URL:   "./" + (&url.URL{Path: entry.Name()}).String(),
+			IsDir: entry.IsDir(),
+		}
+		if le.IsDir {
+			le.URL += "/"
+		} else if info, err := entry.Info(); err == nil {
+			le.Size = info.Size()
+			le.ModTime = info.ModTime()
+		}
+		list = append(list, le)
+	}
+	slices.SortFunc(list, func(a, b listEntry) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listTemplate.Execute(w, struct {
+		Title   string
+		Parent  bool
+		Entries []listEntry
+	}{
+		Title:   "/" + name,
+		Parent:  name != "",
+		Entries: list,
+	}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+var _ http.Handler = &Handler{}
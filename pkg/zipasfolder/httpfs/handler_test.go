@@ -0,0 +1,108 @@
+package httpfs
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+)
+
+func newTestFS(t *testing.T) zipasfolder.FSRW {
+	t.Helper()
+	dir := t.TempDir()
+	fp, err := os.Create(filepath.Join(dir, "archive.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(fp)
+	for name, method := range map[string]uint16{"docs/readme.txt": zip.Deflate, "docs/data.json": zip.Store} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "0123456789abcdefghij")
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	if err := os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := zipasfolder.NewFS(zipasfolder.NewDummyOSRW(dir), 10)
+	t.Cleanup(func() { fsys.(zipasfolder.FSRWClose).Close() })
+	return fsys
+}
+
+func do(t *testing.T, handler http.Handler, method, target string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for key, val := range header {
+		req.Header.Set(key, val)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(newTestFS(t), "/files/")
+
+	resp, body := do(t, handler, http.MethodGet, "/files/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `href="./archive.zip/"`) || !strings.Contains(body, `href="./plain.txt"`) {
+		t.Errorf("invalid root listing %d: %s", resp.StatusCode, body)
+	}
+	resp, _ = do(t, handler, http.MethodGet, "/files/archive.zip/docs", nil)
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/files/archive.zip/docs/" {
+		t.Errorf("no redirect for directory: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	for _, name := range []string{"readme.txt", "data.json"} {
+		resp, body = do(t, handler, http.MethodGet, "/files/archive.zip/docs/"+name, map[string]string{"Range": "bytes=5-9"})
+		if resp.StatusCode != http.StatusPartialContent || body != "56789" {
+			t.Errorf("%s: invalid range response %d: '%s'", name, resp.StatusCode, body)
+		}
+	}
+	resp, _ = do(t, handler, http.MethodHead, "/files/archive.zip/docs/data.json", nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("invalid content type '%s'", ct)
+	}
+	resp, _ = do(t, handler, http.MethodGet, "/files/archive.zip/missing.txt", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file returned %d", resp.StatusCode)
+	}
+	resp, _ = do(t, handler, http.MethodGet, "/other/plain.txt", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("path outside base path returned %d", resp.StatusCode)
+	}
+	resp, _ = do(t, handler, http.MethodPut, "/files/plain.txt", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT returned %d", resp.StatusCode)
+	}
+}
+
+func TestWebDAVHandler(t *testing.T) {
+	handler := NewWebDAVHandler(newTestFS(t), "/dav")
+
+	resp, body := do(t, handler, "PROPFIND", "/dav/archive.zip/docs/", map[string]string{"Depth": "1"})
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "/dav/archive.zip/docs/readme.txt") {
+		t.Errorf("invalid PROPFIND response %d: %s", resp.StatusCode, body)
+	}
+	resp, body = do(t, handler, http.MethodGet, "/dav/archive.zip/docs/readme.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != "0123456789abcdefghij" {
+		t.Errorf("invalid GET response %d: '%s'", resp.StatusCode, body)
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE"} {
+		resp, _ = do(t, handler, method, "/dav/plain.txt", nil)
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("%s returned %d", method, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package httpfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+	"golang.org/x/net/webdav"
+)
+
+// NewWebDAVHandler creates a read-only WebDAV endpoint for fsys below basePath.
+// All modifying methods are rejected with 405.
+func NewWebDAVHandler(fsys zipasfolder.FSRW, basePath string) http.Handler {
+	dav := &webdav.Handler{
+		Prefix:     cleanBasePath(basePath),
+		FileSystem: &davFS{fsys: fsys},
+		LockSystem: webdav.NewMemLS(),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND":
+			dav.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// davFS is a read-only webdav.FileSystem on top of FSRW
+type davFS struct {
+	fsys zipasfolder.FSRW
+}
+
+func davName(name string) string {
+	return strings.Trim(name, "/")
+}
+
+func (dfs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.ErrPermission
+}
+
+func (dfs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, fs.ErrPermission
+	}
+	name = davName(name)
+	info, err := dfs.fsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	df := &davFile{
+		fsys: dfs.fsys,
+		name: name,
+		info: &davFileInfo{FileInfo: info, name: name},
+	}
+	if info.IsDir() {
+		return df, nil
+	}
+	fp, err := dfs.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	df.file = fp
+	return df, nil
+}
+
+func (dfs *davFS) RemoveAll(ctx context.Context, name string) error {
+	return fs.ErrPermission
+}
+
+func (dfs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.ErrPermission
+}
+
+func (dfs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = davName(name)
+	info, err := dfs.fsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &davFileInfo{FileInfo: info, name: name}, nil
+}
+
+// davFileInfo provides the content type from pkg/mimetypes
+type davFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (dfi *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	return ContentType(dfi.name), nil
+}
+
+// davFile is an open file or a directory. Directories are not opened,
+// since directories within archives cannot be opened
+type davFile struct {
+	fsys    zipasfolder.FSRW
+	name    string
+	info    fs.FileInfo
+	file    fs.File
+	readDir bool
+}
+
+func (df *davFile) Close() error {
+	if df.file == nil {
+		return nil
+	}
+	return df.file.Close()
+}
+
+func (df *davFile) Read(p []byte) (int, error) {
+	if df.file == nil {
+		return 0, errors.Errorf("'%s' is a directory", df.name)
+	}
+	return df.file.Read(p)
+}
+
+func (df *davFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := df.file.(io.Seeker)
+	if !ok {
+		return 0, errors.Errorf("'%s' is not seekable", df.name)
+	}
+	return seeker.Seek(offset, whence)
+}
+
+func (df *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if df.file != nil {
+		return nil, errors.Errorf("'%s' is not a directory", df.name)
+	}
+	// the whole directory is returned with the first call
+	if df.readDir {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return []fs.FileInfo{}, nil
+	}
+	df.readDir = true
+	entries, err := fs.ReadDir(df.fsys, df.name)
+	if err != nil {
+		return nil, err
+	}
+	var result = make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get info of '%s'", entry.Name())
+		}
+		result = append(result, &davFileInfo{FileInfo: info, name: entry.Name()})
+	}
+	return result, nil
+}
+
+func (df *davFile) Stat() (fs.FileInfo, error) {
+	return df.info, nil
+}
+
+func (df *davFile) Write(p []byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+var (
+	_ webdav.FileSystem   = &davFS{}
+	_ webdav.File         = &davFile{}
+	_ webdav.ContentTyper = &davFileInfo{}
+)
@@ -0,0 +1,140 @@
+package zipasfolder
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// Leak is a file within an archive which is open longer than the leak threshold
+type Leak struct {
+	Archive string
+	Name    string
+	Opened  time.Time
+	// Caller is the call site which opened the file
+	Caller string
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("'%s' in archive '%s' open since %s, opened at %s", l.Name, l.Archive, l.Opened.Format(time.RFC3339), l.Caller)
+}
+
+type openFile struct {
+	Leak
+	reported bool
+}
+
+// packageDir is the source directory of this package
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return path.Dir(file)
+}()
+
+// caller returns the first call site outside of this package
+func caller() string {
+	var pcs = make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		internal := path.Dir(frame.File) == packageDir && !strings.HasSuffix(frame.File, "_test.go")
+		if !internal && !strings.HasPrefix(frame.Function, "io/fs.") {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// openFiles registers the files opened within archives
+type openFiles struct {
+	files  map[*trackedFile]*openFile
+	lock   sync.Mutex
+	record bool
+}
+
+// track wraps file, so that it is registered until it is closed.
+// The call site is only recorded, if leak detection is enabled.
+func (of *openFiles) track(archive, name string, file fs.File) fs.File {
+	tf := &trackedFile{File: file, files: of}
+	entry := &openFile{Leak: Leak{Archive: archive, Name: name, Opened: time.Now()}}
+	if of.record {
+		entry.Caller = caller()
+	}
+	of.lock.Lock()
+	of.files[tf] = entry
+	of.lock.Unlock()
+	return tf
+}
+
+func (of *openFiles) count() int {
+	of.lock.Lock()
+	defer of.lock.Unlock()
+	return len(of.files)
+}
+
+// leaks returns all files open longer than threshold. If report is set, only files not reported before are returned
+func (of *openFiles) leaks(threshold time.Duration, report bool) []Leak {
+	of.lock.Lock()
+	defer of.lock.Unlock()
+	var result []Leak
+	for _, entry := range of.files {
+		if time.Since(entry.Opened) < threshold || (report && entry.reported) {
+			continue
+		}
+		if report {
+			entry.reported = true
+		}
+		result = append(result, entry.Leak)
+	}
+	slices.SortFunc(result, func(a, b Leak) int {
+		return a.Opened.Compare(b.Opened)
+	})
+	return result
+}
+
+type trackedFile struct {
+	fs.File
+	files *openFiles
+	once  sync.Once
+}
+
+func (tf *trackedFile) Close() error {
+	tf.once.Do(func() {
+		tf.files.lock.Lock()
+		delete(tf.files.files, tf)
+		tf.files.lock.Unlock()
+	})
+	return tf.File.Close()
+}
+
+func (tf *trackedFile) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := tf.File.(io.Seeker)
+	if !ok {
+		return 0, errors.New("file is not seekable")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+func (tf *trackedFile) ReadAt(p []byte, off int64) (int, error) {
+	readerAt, ok := tf.File.(io.ReaderAt)
+	if !ok {
+		return 0, errors.New("file does not support random access")
+	}
+	return readerAt.ReadAt(p, off)
+}
+
+var (
+	_ fs.File     = &trackedFile{}
+	_ io.Seeker   = &trackedFile{}
+	_ io.ReaderAt = &trackedFile{}
+)
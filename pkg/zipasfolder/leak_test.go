@@ -0,0 +1,173 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheOptions(t *testing.T) {
+	dir := t.TempDir()
+	data := createZIP(t, zip.Deflate, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b")})
+	if err := os.WriteFile(filepath.Join(dir, "test.zip"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFSWithOptions(NewDummyOSRW(dir), &Options{CacheSize: 5, Policy: "fifo"}); err == nil {
+		t.Error("unknown policy accepted")
+	}
+
+	var leaks = make(chan Leak, 10)
+	fsys, err := NewFSWithOptions(NewDummyOSRW(dir), &Options{
+		CacheSize:     5,
+		Policy:        CachePolicyARC,
+		IdleTimeout:   time.Hour,
+		SweepInterval: 10 * time.Millisecond,
+		LeakThreshold: 20 * time.Millisecond,
+		LeakFunc: func(leak Leak) {
+			leaks <- leak
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	for _, name := range []string{"test.zip/a.txt", "test.zip/b.txt"} {
+		if _, err := fs.ReadFile(fsys, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := fsys.CacheStats()
+	if stats.Misses != 1 || stats.Hits != 1 || stats.Archives != 1 || stats.OpenFiles != 0 {
+		t.Errorf("invalid cache stats %+v", stats)
+	}
+
+	fp, err := fsys.Open("test.zip/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case leak := <-leaks:
+		if leak.Archive != "test.zip" || leak.Name != "a.txt" || !strings.Contains(leak.Caller, "TestCacheOptions") {
+			t.Errorf("invalid leak %s", leak)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leak not reported")
+	}
+	if len(fsys.Leaks()) != 1 {
+		t.Errorf("leak not listed")
+	}
+	fp.Close()
+	if len(fsys.Leaks()) != 0 || fsys.CacheStats().OpenFiles != 0 {
+		t.Errorf("closed file still listed")
+	}
+
+	// idle timeout is not reached, but the archive can be released explicitly
+	time.Sleep(30 * time.Millisecond)
+	if fsys.CacheStats().Archives != 1 {
+		t.Error("archive closed before idle timeout")
+	}
+	fsys.Release("test.zip")
+	if stats := fsys.CacheStats(); stats.Archives != 0 || stats.Removed != 1 || stats.Evictions != 0 {
+		t.Errorf("archive not released: %+v", stats)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	dir := t.TempDir()
+	var files = map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("file%02d.txt", i)] = bytes.Repeat([]byte{byte('a' + i)}, 100000)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test.zip"), createZIP(t, zip.Deflate, files), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFSWithOptions(NewDummyOSRW(dir), DefaultOptions(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	// all files are open at the same time
+	var open []fs.File
+	for name := range files {
+		fp, err := fsys.Open("test.zip/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		open = append(open, fp)
+	}
+	fsys.ClearUnlocked()
+	if fsys.CacheStats().Archives != 1 {
+		t.Fatal("archive with open files evicted")
+	}
+	var wg sync.WaitGroup
+	for _, fp := range open {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer fp.Close()
+			info, _ := fp.Stat()
+			data, err := io.ReadAll(fp)
+			if err != nil {
+				t.Errorf("cannot read '%s': %v", info.Name(), err)
+				return
+			}
+			if !bytes.Equal(data, files[info.Name()]) {
+				t.Errorf("invalid content of '%s'", info.Name())
+			}
+		}()
+	}
+	wg.Wait()
+	fsys.ClearUnlocked()
+	if fsys.CacheStats().Archives != 0 {
+		t.Error("archive without open files not evicted")
+	}
+}
+
+func TestArchiveReferenceOnEviction(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.zip", "b.zip"} {
+		data := createZIP(t, zip.Store, map[string][]byte{"file.txt": []byte(name)})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys, err := NewFSWithOptions(NewDummyOSRW(dir), DefaultOptions(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	archiveA, releaseA, err := fsys.getArchive("a.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// loading b evicts a from the cache of size 1
+	_, releaseB, err := fsys.getArchive("b.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseB()
+	if fsys.CacheStats().Evictions == 0 {
+		t.Fatal("a.zip not evicted")
+	}
+	fp, err := archiveA.Open("file.txt")
+	if err != nil {
+		t.Fatalf("evicted archive closed while in use: %v", err)
+	}
+	data, err := io.ReadAll(fp)
+	fp.Close()
+	if err != nil || string(data) != "a.zip" {
+		t.Errorf("invalid content %q: %v", data, err)
+	}
+	releaseA()
+}
@@ -0,0 +1,306 @@
+package zipasfolder
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemFS creates an empty in-memory filesystem. It is mainly intended for tests
+func NewMemFS() *MemFS {
+	return &MemFS{
+		entries: map[string]*memEntry{
+			".": {name: ".", dir: true, modTime: time.Now()},
+		},
+	}
+}
+
+type memEntry struct {
+	name    string
+	data    []byte
+	dir     bool
+	modTime time.Time
+}
+
+func (me *memEntry) Name() string {
+	return path.Base(me.name)
+}
+
+func (me *memEntry) Size() int64 {
+	return int64(len(me.data))
+}
+
+func (me *memEntry) Mode() fs.FileMode {
+	if me.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (me *memEntry) ModTime() time.Time {
+	return me.modTime
+}
+
+func (me *memEntry) IsDir() bool {
+	return me.dir
+}
+
+func (me *memEntry) Sys() any {
+	return nil
+}
+
+// MemFS is an in-memory FSRW. Files are written on Close
+type MemFS struct {
+	entries map[string]*memEntry
+	lock    sync.RWMutex
+}
+
+// memName converts name to the key of an entry
+func memName(name string) string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (mfs *MemFS) Open(name string) (fs.File, error) {
+	mfs.lock.RLock()
+	defer mfs.lock.RUnlock()
+	entry, ok := mfs.entries[memName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info := *entry
+	if entry.dir {
+		return &memDir{info: &info, fsys: mfs}, nil
+	}
+	return &memFile{Reader: bytes.NewReader(entry.data), info: &info}, nil
+}
+
+func (mfs *MemFS) Stat(name string) (fs.FileInfo, error) {
+	mfs.lock.RLock()
+	defer mfs.lock.RUnlock()
+	entry, ok := mfs.entries[memName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	info := *entry
+	return &info, nil
+}
+
+func (mfs *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	mfs.lock.RLock()
+	defer mfs.lock.RUnlock()
+	name = memName(name)
+	entry, ok := mfs.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !entry.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var result = []fs.DirEntry{}
+	for n, e := range mfs.entries {
+		if n != "." && path.Dir(n) == name {
+			info := *e
+			result = append(result, fs.FileInfoToDirEntry(&info))
+		}
+	}
+	slices.SortFunc(result, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return result, nil
+}
+
+func (mfs *MemFS) ReadFile(name string) ([]byte, error) {
+	mfs.lock.RLock()
+	defer mfs.lock.RUnlock()
+	entry, ok := mfs.entries[memName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return slices.Clone(entry.data), nil
+}
+
+// checkParent checks whether the parent directory of name exists. It has to be called with lock
+func (mfs *MemFS) checkParent(op, name string) error {
+	parent, ok := mfs.entries[path.Dir(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.dir {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+func (mfs *MemFS) Create(name string) (FileW, error) {
+	mfs.lock.RLock()
+	defer mfs.lock.RUnlock()
+	name = memName(name)
+	if err := mfs.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	if entry, ok := mfs.entries[name]; ok && entry.dir {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	return &memWriter{fsys: mfs, name: name}, nil
+}
+
+func (mfs *MemFS) WriteFile(name string, data []byte) error {
+	mfs.lock.Lock()
+	defer mfs.lock.Unlock()
+	name = memName(name)
+	if err := mfs.checkParent("write", name); err != nil {
+		return err
+	}
+	if entry, ok := mfs.entries[name]; ok && entry.dir {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	mfs.entries[name] = &memEntry{name: name, data: slices.Clone(data), modTime: time.Now()}
+	return nil
+}
+
+func (mfs *MemFS) MkDir(name string) error {
+	mfs.lock.Lock()
+	defer mfs.lock.Unlock()
+	name = memName(name)
+	if err := mfs.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if _, ok := mfs.entries[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	mfs.entries[name] = &memEntry{name: name, dir: true, modTime: time.Now()}
+	return nil
+}
+
+// Remove removes a file or an empty directory
+func (mfs *MemFS) Remove(name string) error {
+	mfs.lock.Lock()
+	defer mfs.lock.Unlock()
+	name = memName(name)
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := mfs.entries[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for n := range mfs.entries {
+		if strings.HasPrefix(n, name+"/") {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	delete(mfs.entries, name)
+	return nil
+}
+
+// Rename moves a file or a directory with all its content. An existing file at newPath is replaced
+func (mfs *MemFS) Rename(oldPath, newPath string) error {
+	mfs.lock.Lock()
+	defer mfs.lock.Unlock()
+	oldPath = memName(oldPath)
+	newPath = memName(newPath)
+	entry, ok := mfs.entries[oldPath]
+	if !ok || oldPath == "." {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	if err := mfs.checkParent("rename", newPath); err != nil {
+		return err
+	}
+	if target, ok := mfs.entries[newPath]; ok && (target.dir || entry.dir) {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: fs.ErrExist}
+	}
+	if entry.dir && strings.HasPrefix(newPath, oldPath+"/") {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: fs.ErrInvalid}
+	}
+	for n, e := range mfs.entries {
+		if n == oldPath || strings.HasPrefix(n, oldPath+"/") {
+			delete(mfs.entries, n)
+			e.name = newPath + strings.TrimPrefix(n, oldPath)
+			mfs.entries[e.name] = e
+		}
+	}
+	return nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (mf *memFile) Stat() (fs.FileInfo, error) {
+	return mf.info, nil
+}
+
+func (mf *memFile) Close() error {
+	return nil
+}
+
+type memDir struct {
+	info   fs.FileInfo
+	fsys   *MemFS
+	offset int
+}
+
+func (md *memDir) Stat() (fs.FileInfo, error) {
+	return md.info, nil
+}
+
+func (md *memDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: md.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (md *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := md.fsys.ReadDir(md.info.(*memEntry).name)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[min(md.offset, len(entries)):]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(n, len(entries))]
+	}
+	md.offset += len(entries)
+	return entries, nil
+}
+
+func (md *memDir) Close() error {
+	return nil
+}
+
+// memWriter buffers the data and stores it on Close
+type memWriter struct {
+	bytes.Buffer
+	fsys   *MemFS
+	name   string
+	closed bool
+}
+
+func (mw *memWriter) Close() error {
+	if mw.closed {
+		return &fs.PathError{Op: "close", Path: mw.name, Err: fs.ErrClosed}
+	}
+	mw.closed = true
+	return mw.fsys.WriteFile(mw.name, mw.Bytes())
+}
+
+var (
+	_ FSRW           = &MemFS{}
+	_ fs.ReadDirFS   = &MemFS{}
+	_ fs.ReadFileFS  = &MemFS{}
+	_ io.ReaderAt    = &memFile{}
+	_ fs.ReadDirFile = &memDir{}
+	_ fs.FileInfo    = &memEntry{}
+)
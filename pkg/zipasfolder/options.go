@@ -0,0 +1,86 @@
+package zipasfolder
+
+import (
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/bluele/gcache"
+)
+
+// CachePolicy is the eviction policy of the archive cache
+type CachePolicy string
+
+const (
+	CachePolicyLRU CachePolicy = gcache.TYPE_LRU
+	CachePolicyLFU CachePolicy = gcache.TYPE_LFU
+	CachePolicyARC CachePolicy = gcache.TYPE_ARC
+)
+
+// Options configures the archive cache of FS
+type Options struct {
+	// CacheSize is the maximum number of open archives
+	CacheSize int
+	// Policy selects the archive to evict if the cache is full. Default is CachePolicyLRU
+	Policy CachePolicy
+	// IdleTimeout is the time after which unused archives without open files are closed.
+	// With zero, all archives without open files are closed at every sweep
+	IdleTimeout time.Duration
+	// SweepInterval is the interval of the check for idle archives and leaks. Default is one minute
+	SweepInterval time.Duration
+	// LeakThreshold enables the leak detector. Files open longer than LeakThreshold are reported to LeakFunc
+	LeakThreshold time.Duration
+	// LeakFunc is called once for every file open longer than LeakThreshold
+	LeakFunc func(leak Leak)
+	// PasswordFunc provides the passwords of encrypted zip entries
+	PasswordFunc PasswordFunc
+}
+
+// DefaultOptions returns the options used by NewFS
+func DefaultOptions(cacheSize int) *Options {
+	return &Options{
+		CacheSize:     cacheSize,
+		Policy:        CachePolicyLRU,
+		SweepInterval: time.Minute,
+	}
+}
+
+func (opts *Options) check() error {
+	if opts.CacheSize <= 0 {
+		return errors.Errorf("invalid cache size %d", opts.CacheSize)
+	}
+	switch opts.Policy {
+	case "":
+		opts.Policy = CachePolicyLRU
+	case CachePolicyLRU, CachePolicyLFU, CachePolicyARC:
+	default:
+		return errors.Errorf("unknown cache policy '%s'", opts.Policy)
+	}
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = time.Minute
+	}
+	return nil
+}
+
+// CacheStats are the counters of the archive cache
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Evictions are the archives dropped because the cache was full
+	Evictions uint64
+	// Expired are the archives closed after the idle timeout
+	Expired uint64
+	// Removed are the archives dropped by Release, ClearUnlocked or a commit
+	Removed uint64
+	// Archives is the number of cached archives
+	Archives int
+	// OpenFiles is the number of files opened via FS and not closed yet
+	OpenFiles int
+}
+
+// HitRate returns the ratio of hits to lookups
+func (cs CacheStats) HitRate() float64 {
+	if cs.Hits+cs.Misses == 0 {
+		return 0
+	}
+	return float64(cs.Hits) / float64(cs.Hits+cs.Misses)
+}
@@ -0,0 +1,146 @@
+package zipasfolder
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// fsPath converts a name used by FS to a valid fs.FS path
+func fsPath(name string) string {
+	name = strings.Trim(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// NewOSDirFS creates an FSRW for the directory dir. Paths which leave dir
+// via ".." or symbolic links pointing outside of dir are rejected.
+func NewOSDirFS(dir string) (*OSDirFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open directory '%s'", dir)
+	}
+	return &OSDirFS{root: root}, nil
+}
+
+// OSDirFS is a chroot-safe FSRW for a directory based on os.Root
+type OSDirFS struct {
+	root *os.Root
+}
+
+// rootPath checks name for escapes. Unlike FS, ".." is not resolved but rejected
+func rootPath(op, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return fsPath(name), nil
+}
+
+func (ofs *OSDirFS) Open(name string) (fs.File, error) {
+	name, err := rootPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return ofs.root.Open(name)
+}
+
+func (ofs *OSDirFS) Stat(name string) (fs.FileInfo, error) {
+	name, err := rootPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return ofs.root.Stat(name)
+}
+
+func (ofs *OSDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name, err := rootPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ofs.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (ofs *OSDirFS) ReadFile(name string) ([]byte, error) {
+	name, err := rootPath("read", name)
+	if err != nil {
+		return nil, err
+	}
+	return ofs.root.ReadFile(name)
+}
+
+func (ofs *OSDirFS) Create(name string) (FileW, error) {
+	name, err := rootPath("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return ofs.root.Create(name)
+}
+
+func (ofs *OSDirFS) MkDir(name string) error {
+	name, err := rootPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return ofs.root.Mkdir(name, 0777)
+}
+
+func (ofs *OSDirFS) Remove(name string) error {
+	name, err := rootPath("remove", name)
+	if err != nil {
+		return err
+	}
+	return ofs.root.Remove(name)
+}
+
+func (ofs *OSDirFS) Rename(oldPath, newPath string) error {
+	oldPath, err := rootPath("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	newPath, err = rootPath("rename", newPath)
+	if err != nil {
+		return err
+	}
+	return ofs.root.Rename(oldPath, newPath)
+}
+
+func (ofs *OSDirFS) WriteFile(name string, data []byte) error {
+	name, err := rootPath("write", name)
+	if err != nil {
+		return err
+	}
+	return ofs.root.WriteFile(name, data, 0666)
+}
+
+// Close releases the directory
+func (ofs *OSDirFS) Close() error {
+	return ofs.root.Close()
+}
+
+var (
+	_ FSRW          = &OSDirFS{}
+	_ FSRWClose     = &OSDirFS{}
+	_ fs.ReadDirFS  = &OSDirFS{}
+	_ fs.ReadFileFS = &OSDirFS{}
+)
@@ -0,0 +1,56 @@
+package zipasfolder
+
+import (
+	"io/fs"
+)
+
+// NewReadOnlyFS wraps fsys as FSRW. All write operations return fs.ErrPermission
+func NewReadOnlyFS(fsys fs.FS) FSRW {
+	return &readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (rofs *readOnlyFS) Open(name string) (fs.File, error) {
+	return rofs.fsys.Open(fsPath(name))
+}
+
+func (rofs *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(rofs.fsys, fsPath(name))
+}
+
+func (rofs *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(rofs.fsys, fsPath(name))
+}
+
+func (rofs *readOnlyFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(rofs.fsys, fsPath(name))
+}
+
+func (rofs *readOnlyFS) Create(path string) (FileW, error) {
+	return nil, &fs.PathError{Op: "create", Path: path, Err: fs.ErrPermission}
+}
+
+func (rofs *readOnlyFS) MkDir(path string) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrPermission}
+}
+
+func (rofs *readOnlyFS) Remove(path string) error {
+	return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrPermission}
+}
+
+func (rofs *readOnlyFS) Rename(oldPath, newPath string) error {
+	return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrPermission}
+}
+
+func (rofs *readOnlyFS) WriteFile(path string, data []byte) error {
+	return &fs.PathError{Op: "write", Path: path, Err: fs.ErrPermission}
+}
+
+var (
+	_ FSRW          = &readOnlyFS{}
+	_ fs.ReadDirFS  = &readOnlyFS{}
+	_ fs.ReadFileFS = &readOnlyFS{}
+)
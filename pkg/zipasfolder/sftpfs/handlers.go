@@ -0,0 +1,243 @@
+package sftpfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+	"github.com/pkg/sftp"
+)
+
+// NewHandlers creates the sftp request server handlers for fsys
+func NewHandlers(fsys zipasfolder.FSRW) sftp.Handlers {
+	h := &handlers{fsys: fsys}
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+type handlers struct {
+	fsys zipasfolder.FSRW
+}
+
+// fsName converts the absolute sftp path to a name within fsys
+func fsName(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// sftpError maps filesystem errors to sftp status codes
+func sftpError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return os.ErrNotExist
+	case errors.Is(err, fs.ErrPermission):
+		return sftp.ErrSSHFxPermissionDenied
+	default:
+		return err
+	}
+}
+
+func (h *handlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	fp, err := h.fsys.Open(fsName(r.Filepath))
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	readerAt, ok := fp.(io.ReaderAt)
+	if !ok {
+		fp.Close()
+		return nil, errors.Errorf("file '%s' does not support random access", r.Filepath)
+	}
+	return &fileReaderAt{ReaderAt: readerAt, file: fp}, nil
+}
+
+func (h *handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	name := fsName(r.Filepath)
+	fp, err := h.fsys.Create(name)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	// incomplete uploads must not be stored
+	abort := func() error {
+		if a, ok := fp.(zipasfolder.FileWAbort); ok {
+			return a.Abort()
+		}
+		fp.Close()
+		return h.fsys.Remove(name)
+	}
+	return newSequentialWriterAt(fp, abort), nil
+}
+
+func (h *handlers) Filecmd(r *sftp.Request) error {
+	name := fsName(r.Filepath)
+	switch r.Method {
+	case "Mkdir":
+		return sftpError(h.fsys.MkDir(name))
+	case "Setstat":
+		// times and permissions are not supported, but clients set them after upload.
+		// Changing the size would silently corrupt the file
+		if r.AttrFlags().Size {
+			info, err := h.fsys.Stat(name)
+			if err != nil {
+				return sftpError(err)
+			}
+			if uint64(info.Size()) != r.Attributes().Size {
+				return sftp.ErrSSHFxOpUnsupported
+			}
+		}
+		return nil
+	case "Rename":
+		return sftpError(h.fsys.Rename(name, fsName(r.Target)))
+	case "Remove", "Rmdir":
+		return sftpError(h.fsys.Remove(name))
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+func (h *handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := fsName(r.Filepath)
+	switch r.Method {
+	case "List":
+		entries, err := fs.ReadDir(h.fsys, name)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		var infos = make(listerAt, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, sftpError(err)
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	case "Stat":
+		info, err := h.fsys.Stat(name)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		return listerAt{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileReaderAt reads concurrently from the io.ReaderAt of file
+type fileReaderAt struct {
+	io.ReaderAt
+	file fs.File
+}
+
+func (fra *fileReaderAt) Close() error {
+	return fra.file.Close()
+}
+
+// MaxPendingSize limits the memory of blocks received out of order per upload
+var MaxPendingSize int64 = 64 << 20
+
+// sequentialWriterAt writes to a sequential writer. Concurrent sftp clients send
+// blocks out of order, so blocks are held back until the gap before them is filled
+type sequentialWriterAt struct {
+	writer      io.WriteCloser
+	abort       func() error
+	offset      int64
+	pending     map[int64][]byte
+	pendingSize int64
+	err         error
+	lock        sync.Mutex
+}
+
+func newSequentialWriterAt(writer io.WriteCloser, abort func() error) *sequentialWriterAt {
+	return &sequentialWriterAt{
+		writer:  writer,
+		abort:   abort,
+		pending: map[int64][]byte{},
+	}
+}
+
+func (swa *sequentialWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	swa.lock.Lock()
+	defer swa.lock.Unlock()
+	if swa.err != nil {
+		return 0, swa.err
+	}
+	if off < swa.offset {
+		return 0, errors.Errorf("cannot write at offset %d before current offset %d", off, swa.offset)
+	}
+	if off > swa.offset {
+		size := swa.pendingSize + int64(len(p)) - int64(len(swa.pending[off]))
+		if size > MaxPendingSize {
+			swa.err = errors.Errorf("more than %d bytes pending after offset %d", MaxPendingSize, swa.offset)
+			swa.pending = map[int64][]byte{}
+			swa.pendingSize = 0
+			return 0, swa.err
+		}
+		swa.pending[off] = append([]byte{}, p...)
+		swa.pendingSize = size
+		return len(p), nil
+	}
+	if _, err := swa.writer.Write(p); err != nil {
+		swa.err = err
+		return 0, err
+	}
+	swa.offset += int64(len(p))
+	for {
+		data, ok := swa.pending[swa.offset]
+		if !ok {
+			break
+		}
+		delete(swa.pending, swa.offset)
+		swa.pendingSize -= int64(len(data))
+		if _, err := swa.writer.Write(data); err != nil {
+			swa.err = err
+			return 0, err
+		}
+		swa.offset += int64(len(data))
+	}
+	return len(p), nil
+}
+
+// Close stores the file. Failed or incomplete uploads are discarded
+func (swa *sequentialWriterAt) Close() error {
+	swa.lock.Lock()
+	defer swa.lock.Unlock()
+	if swa.err != nil {
+		swa.abort()
+		return errors.Wrap(swa.err, "upload discarded")
+	}
+	if len(swa.pending) > 0 {
+		swa.abort()
+		return errors.Errorf("incomplete write: %d blocks after offset %d missing, upload discarded", len(swa.pending), swa.offset)
+	}
+	return swa.writer.Close()
+}
+
+var (
+	_ sftp.FileReader = &handlers{}
+	_ sftp.FileWriter = &handlers{}
+	_ sftp.FileCmder  = &handlers{}
+	_ sftp.FileLister = &handlers{}
+	_ io.WriterAt     = &sequentialWriterAt{}
+)
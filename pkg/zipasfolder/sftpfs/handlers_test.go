@@ -0,0 +1,85 @@
+package sftpfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+)
+
+func TestSequentialWriterAt(t *testing.T) {
+	dir := t.TempDir()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "archive.zip"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := zipasfolder.NewFS(zipasfolder.NewDummyOSRW(dir), 10)
+	defer fsys.(zipasfolder.FSRWClose).Close()
+
+	var create = func(name string) *sequentialWriterAt {
+		fp, err := fsys.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newSequentialWriterAt(fp, fp.(zipasfolder.FileWAbort).Abort)
+	}
+
+	// blocks out of order are written once the gap is filled
+	swa := create("archive.zip/complete.txt")
+	if _, err := swa.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swa.WriteAt([]byte("hello "), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := swa.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a missing block discards the upload
+	swa = create("archive.zip/gap.txt")
+	if _, err := swa.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := swa.Close(); err == nil {
+		t.Error("incomplete upload not reported")
+	}
+
+	// pending blocks above MaxPendingSize fail the upload
+	defer func(size int64) { MaxPendingSize = size }(MaxPendingSize)
+	MaxPendingSize = 8
+	swa = create("archive.zip/large.txt")
+	if _, err := swa.WriteAt([]byte("0123"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swa.WriteAt([]byte("0123456789"), 8); err == nil {
+		t.Error("pending size above limit accepted")
+	}
+	if _, err := swa.WriteAt([]byte("0123"), 0); err == nil {
+		t.Error("write after failure accepted")
+	}
+	if err := swa.Close(); err == nil {
+		t.Error("failed upload not reported")
+	}
+
+	if err := fsys.(zipasfolder.FSRWClose).Close(); err != nil {
+		t.Fatal(err)
+	}
+	fsys = zipasfolder.NewFS(zipasfolder.NewDummyOSRW(dir), 10)
+	defer fsys.(zipasfolder.FSRWClose).Close()
+	data, err := fs.ReadFile(fsys, "archive.zip/complete.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("invalid content %q: %v", data, err)
+	}
+	for _, name := range []string{"archive.zip/gap.txt", "archive.zip/large.txt"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			t.Errorf("discarded upload '%s' stored", name)
+		}
+	}
+}
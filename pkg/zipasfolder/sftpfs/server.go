@@ -0,0 +1,207 @@
+package sftpfs
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"emperror.dev/errors"
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+	"github.com/op/go-logging"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Server serves an FSRW via sftp. Clients authenticate with public keys
+type Server struct {
+	fsys     zipasfolder.FSRW
+	config   *ssh.ServerConfig
+	log      *logging.Logger
+	listener net.Listener
+	conns    map[net.Conn]bool
+	lock     sync.Mutex
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+// NewServer creates an sftp server for fsys. hostKeys are the private key files of the server,
+// authorizedKeys are files in authorized_keys format containing the keys of all clients
+func NewServer(fsys zipasfolder.FSRW, hostKeys []string, authorizedKeys []string, log *logging.Logger) (*Server, error) {
+	var authorized []ssh.PublicKey
+	for _, ak := range authorizedKeys {
+		data, err := os.ReadFile(ak)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read authorized keys file %s", ak)
+		}
+		for len(bytes.TrimSpace(data)) > 0 {
+			key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot parse authorized keys file %s", ak)
+			}
+			authorized = append(authorized, key)
+			data = rest
+		}
+	}
+	srv := &Server{
+		fsys:  fsys,
+		log:   log,
+		conns: map[net.Conn]bool{},
+		config: &ssh.ServerConfig{
+			PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				for _, ak := range authorized {
+					if bytes.Equal(ak.Marshal(), key.Marshal()) {
+						return &ssh.Permissions{
+							Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(key)},
+						}, nil
+					}
+				}
+				return nil, errors.Errorf("unknown public key for %s", conn.User())
+			},
+		},
+	}
+	for _, hk := range hostKeys {
+		key, err := os.ReadFile(hk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read host key file %s", hk)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse host key %s", hk)
+		}
+		srv.config.AddHostKey(signer)
+	}
+	if len(hostKeys) == 0 {
+		return nil, errors.New("no host key")
+	}
+	return srv, nil
+}
+
+// ListenAndServe listens on the tcp address addr and serves until Close is called
+func (srv *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "cannot listen on %s", addr)
+	}
+	return srv.Serve(listener)
+}
+
+// Serve accepts connections on listener until Close is called
+func (srv *Server) Serve(listener net.Listener) error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		listener.Close()
+		return errors.New("server closed")
+	}
+	srv.listener = listener
+	srv.lock.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			srv.lock.Lock()
+			closed := srv.closed
+			srv.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return errors.Wrap(err, "cannot accept connection")
+		}
+		srv.lock.Lock()
+		if srv.closed {
+			srv.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		srv.conns[conn] = true
+		srv.wg.Add(1)
+		srv.lock.Unlock()
+		go func() {
+			defer srv.wg.Done()
+			srv.handleConn(conn)
+			srv.lock.Lock()
+			delete(srv.conns, conn)
+			srv.lock.Unlock()
+		}()
+	}
+}
+
+// Addr returns the address of the listener
+func (srv *Server) Addr() net.Addr {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
+// Close stops the listener, closes all connections and waits until they are finished
+func (srv *Server) Close() error {
+	srv.lock.Lock()
+	if srv.closed {
+		srv.lock.Unlock()
+		return nil
+	}
+	srv.closed = true
+	var err error
+	if srv.listener != nil {
+		err = srv.listener.Close()
+	}
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.lock.Unlock()
+	srv.wg.Wait()
+	return err
+}
+
+func (srv *Server) handleConn(nConn net.Conn) {
+	defer nConn.Close()
+	sConn, chans, reqs, err := ssh.NewServerConn(nConn, srv.config)
+	if err != nil {
+		srv.log.Infof("ssh handshake with %s failed: %v", nConn.RemoteAddr(), err)
+		return
+	}
+	defer sConn.Close()
+	srv.log.Infof("sftp connection from %s@%s (%s)", sConn.User(), sConn.RemoteAddr(), sConn.Permissions.Extensions["pubkey-fp"])
+	go ssh.DiscardRequests(reqs)
+	var wg sync.WaitGroup
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			srv.log.Errorf("cannot accept channel from %s: %v", sConn.RemoteAddr(), err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.handleSession(channel, requests)
+		}()
+	}
+	wg.Wait()
+}
+
+// handleSession starts the sftp subsystem. All other requests are rejected
+func (srv *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "subsystem" || len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+		server := sftp.NewRequestServer(channel, NewHandlers(srv.fsys))
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			srv.log.Errorf("sftp session failed: %v", err)
+		}
+		server.Close()
+		return
+	}
+}
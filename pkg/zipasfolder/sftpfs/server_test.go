@@ -0,0 +1,148 @@
+package sftpfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/je4/utils/v2/pkg/zipasfolder"
+	"github.com/op/go-logging"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T, keyFile string) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	keyDir := t.TempDir()
+	var content = bytes.Repeat([]byte("0123456789"), 100000)
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create("docs/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "archive.zip"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hostSigner := newSigner(t, filepath.Join(keyDir, "host_key"))
+	clientSigner := newSigner(t, filepath.Join(keyDir, "client_key"))
+	otherSigner := newSigner(t, filepath.Join(keyDir, "other_key"))
+	if err := os.WriteFile(filepath.Join(keyDir, "authorized_keys"), ssh.MarshalAuthorizedKey(clientSigner.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := zipasfolder.NewFS(zipasfolder.NewDummyOSRW(dir), 10)
+	defer fsys.(zipasfolder.FSRWClose).Close()
+	srv, err := NewServer(fsys, []string{filepath.Join(keyDir, "host_key")}, []string{filepath.Join(keyDir, "authorized_keys")}, logging.MustGetLogger("sftpfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	var dial = func(signer ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            "test",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.FixedHostKey(hostSigner.PublicKey()),
+		})
+	}
+	if _, err := dial(otherSigner); err == nil {
+		t.Error("unknown key accepted")
+	}
+	sshClient, err := dial(clientSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshClient.Close()
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	infos, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || !infos[0].IsDir() || infos[0].Name() != "archive.zip" {
+		t.Errorf("archive not shown as directory: %v", infos)
+	}
+	fp, err := client.Open("/archive.zip/docs/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(fp)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("invalid content of zip entry (%d bytes)", len(data))
+	}
+	if _, err := client.Stat("/archive.zip/missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("stat of missing file returned %v", err)
+	}
+
+	wfp, err := client.Create("/upload.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wfp.ReadFromWithConcurrency(bytes.NewReader(content), 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := wfp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := os.ReadFile(filepath.Join(dir, "upload.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(uploaded, content) {
+		t.Errorf("invalid uploaded content (%d bytes)", len(uploaded))
+	}
+	if err := client.Chtimes("/upload.bin", time.Now(), time.Now()); err != nil {
+		t.Errorf("setting times failed: %v", err)
+	}
+	if err := client.Truncate("/upload.bin", 10); err == nil {
+		t.Error("truncate not rejected")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "upload.bin")); err != nil || info.Size() != int64(len(content)) {
+		t.Errorf("upload changed by truncate: %v", err)
+	}
+}
@@ -44,3 +44,17 @@ func (sfs *subFS) Create(path string) (FileW, error) {
 func (sfs *subFS) MkDir(path string) error {
 	return sfs.fsys.MkDir(filepath.ToSlash(filepath.Join(sfs.dir, path)))
 }
+
+func (sfs *subFS) Remove(path string) error {
+	return sfs.fsys.Remove(filepath.ToSlash(filepath.Join(sfs.dir, path)))
+}
+
+func (sfs *subFS) Rename(oldPath, newPath string) error {
+	return sfs.fsys.Rename(filepath.ToSlash(filepath.Join(sfs.dir, oldPath)), filepath.ToSlash(filepath.Join(sfs.dir, newPath)))
+}
+
+func (sfs *subFS) WriteFile(path string, data []byte) error {
+	return sfs.fsys.WriteFile(filepath.ToSlash(filepath.Join(sfs.dir, path)), data)
+}
+
+var _ FSRW = &subFS{}
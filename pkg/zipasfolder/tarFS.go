@@ -0,0 +1,217 @@
+package zipasfolder
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"emperror.dev/errors"
+)
+
+func init() {
+	for ext, opener := range map[string]ArchiveOpener{
+		".tar":     NewTarFS,
+		".tar.gz":  openTarGz,
+		".tgz":     openTarGz,
+		".tar.bz2": openTarBz2,
+		".tbz2":    openTarBz2,
+	} {
+		if err := RegisterArchiveOpener(ext, opener); err != nil {
+			panic(err)
+		}
+	}
+}
+
+type tarEntry struct {
+	header *tar.Header
+	offset int64
+}
+
+// TarFS is a read-only view of an uncompressed tar archive.
+// Compressed tar archives are decompressed to a spool file first.
+type TarFS struct {
+	file    *ArchiveFile
+	entries map[string]*tarEntry
+	names   []string
+	open    atomic.Int64
+}
+
+// NewTarFS reads the headers of the tar archive file
+func NewTarFS(file *ArchiveFile) (ArchiveFS, error) {
+	sr := io.NewSectionReader(file, 0, file.Size())
+	tr := tar.NewReader(sr)
+	tfs := &TarFS{
+		file:    file,
+		entries: map[string]*tarEntry{},
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Release()
+			return nil, errors.Wrap(err, "cannot read tar header")
+		}
+		offset, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			file.Release()
+			return nil, errors.Wrap(err, "cannot get tar entry offset")
+		}
+		name := strings.Trim(path.Clean("/"+header.Name), "/")
+		if name == "" {
+			continue
+		}
+		tfs.entries[name] = &tarEntry{header: header, offset: offset}
+	}
+	// synthesize implicit directories
+	for name := range tfs.entries {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := tfs.entries[dir]; ok {
+				break
+			}
+			tfs.entries[dir] = &tarEntry{header: &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}}
+		}
+	}
+	for name := range tfs.entries {
+		tfs.names = append(tfs.names, name)
+	}
+	slices.Sort(tfs.names)
+	return tfs, nil
+}
+
+func openTarGz(file *ArchiveFile) (ArchiveFS, error) {
+	defer file.Release()
+	gr, err := gzip.NewReader(io.NewSectionReader(file, 0, file.Size()))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create gzip reader")
+	}
+	spooled, err := spoolFile(gr)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decompress tar archive")
+	}
+	return NewTarFS(spooled)
+}
+
+func openTarBz2(file *ArchiveFile) (ArchiveFS, error) {
+	defer file.Release()
+	spooled, err := spoolFile(bzip2.NewReader(io.NewSectionReader(file, 0, file.Size())))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decompress tar archive")
+	}
+	return NewTarFS(spooled)
+}
+
+func (tfs *TarFS) Stat(name string) (fs.FileInfo, error) {
+	entry, ok := tfs.entries[strings.Trim(name, "/")]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return entry.header.FileInfo(), nil
+}
+
+func (tfs *TarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = strings.Trim(name, "/")
+	if name == "." {
+		name = ""
+	}
+	var prefix string
+	if name != "" {
+		entry, ok := tfs.entries[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		if !entry.header.FileInfo().IsDir() {
+			return nil, errors.Errorf("'%s' is not a directory", name)
+		}
+		prefix = name + "/"
+	}
+	var result = []fs.DirEntry{}
+	for _, n := range tfs.names {
+		if !strings.HasPrefix(n, prefix) || strings.Contains(n[len(prefix):], "/") {
+			continue
+		}
+		result = append(result, fs.FileInfoToDirEntry(tfs.entries[n].header.FileInfo()))
+	}
+	return result, nil
+}
+
+func (tfs *TarFS) Open(name string) (fs.File, error) {
+	entry, ok := tfs.entries[strings.Trim(name, "/")]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	if !entry.header.FileInfo().Mode().IsRegular() {
+		return nil, errors.Errorf("'%s' is not a regular file", name)
+	}
+	tfs.open.Add(1)
+	file := tfs.file.Acquire()
+	return NewFile(
+		entry.header.FileInfo(),
+		&tarFileReader{
+			SectionReader: io.NewSectionReader(file, entry.offset, entry.header.Size),
+			close:         file.Release,
+		},
+		func() { tfs.open.Add(-1) },
+	), nil
+}
+
+// RawFile returns the data of a regular file, tar entries are never compressed
+func (tfs *TarFS) RawFile(name string) (*ArchiveFile, bool, error) {
+	entry, ok := tfs.entries[strings.Trim(name, "/")]
+	if !ok {
+		return nil, false, fs.ErrNotExist
+	}
+	if !entry.header.FileInfo().Mode().IsRegular() {
+		return nil, false, errors.Errorf("'%s' is not a regular file", name)
+	}
+	return tfs.file.Section(entry.offset, entry.header.Size), true, nil
+}
+
+func (tfs *TarFS) IsLocked() bool {
+	return tfs.open.Load() > 0
+}
+
+// Close releases the archive file. Open files keep it alive until they are closed
+func (tfs *TarFS) Close() error {
+	return tfs.file.Release()
+}
+
+type tarFileReader struct {
+	*io.SectionReader
+	close  func() error
+	closed atomic.Bool
+}
+
+func (tfr *tarFileReader) Read(p []byte) (int, error) {
+	if tfr.closed.Load() {
+		return 0, fs.ErrClosed
+	}
+	return tfr.SectionReader.Read(p)
+}
+
+func (tfr *tarFileReader) ReadAt(p []byte, off int64) (int, error) {
+	if tfr.closed.Load() {
+		return 0, fs.ErrClosed
+	}
+	return tfr.SectionReader.ReadAt(p, off)
+}
+
+// Close releases the reference to the archive file. Further calls return fs.ErrClosed
+func (tfr *tarFileReader) Close() error {
+	if tfr.closed.Swap(true) {
+		return fs.ErrClosed
+	}
+	return tfr.close()
+}
+
+var (
+	_ ArchiveFS    = &TarFS{}
+	_ ArchiveFSRaw = &TarFS{}
+)
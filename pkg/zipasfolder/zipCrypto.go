@@ -0,0 +1,297 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"emperror.dev/errors"
+)
+
+// PasswordFunc returns the password of an encrypted entry of an archive
+type PasswordFunc func(archive, name string) (string, error)
+
+var (
+	// ErrPasswordRequired is returned for encrypted entries if no password is available
+	ErrPasswordRequired = errors.New("password required")
+	// ErrInvalidPassword is returned if the password verification of an encrypted entry fails
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrUnsupportedEncryption is returned for encryption methods other than WinZip AES
+	ErrUnsupportedEncryption = errors.New("unsupported encryption")
+)
+
+// ChecksumError is returned if the CRC32 of an entry does not match after a full read
+type ChecksumError struct {
+	Name     string
+	Expected uint32
+	Actual   uint32
+}
+
+func (ce *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch of '%s': expected %08x, got %08x", ce.Name, ce.Expected, ce.Actual)
+}
+
+// AuthenticationError is returned if the authentication code of an AES encrypted entry does not match
+type AuthenticationError struct {
+	Name string
+}
+
+func (ae *AuthenticationError) Error() string {
+	return fmt.Sprintf("authentication of encrypted entry '%s' failed", ae.Name)
+}
+
+const (
+	zipMethodAES    = 99
+	zipExtraAES     = 0x9901
+	zipFlagEncrypt  = 0x1
+	aesVerifierSize = 2
+	aesAuthCodeSize = 10
+)
+
+// aesExtra is the WinZip AES extra field
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+func (ae *aesExtra) keySize() int {
+	return 8 + 8*int(ae.strength)
+}
+
+func (ae *aesExtra) saltSize() int {
+	return 4 + 4*int(ae.strength)
+}
+
+func parseAESExtra(extra []byte) (*aesExtra, error) {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if tag == zipExtraAES {
+			if size < 7 || string(extra[2:4]) != "AE" {
+				return nil, errors.New("invalid aes extra field")
+			}
+			ae := &aesExtra{
+				version:  binary.LittleEndian.Uint16(extra),
+				strength: extra[4],
+				method:   binary.LittleEndian.Uint16(extra[5:]),
+			}
+			if ae.strength < 1 || ae.strength > 3 {
+				return nil, errors.Errorf("invalid aes strength %d", ae.strength)
+			}
+			return ae, nil
+		}
+		extra = extra[size:]
+	}
+	return nil, errors.New("aes extra field missing")
+}
+
+func isEncrypted(f *zip.File) bool {
+	return f.Flags&zipFlagEncrypt != 0
+}
+
+// openEntry opens an entry for sequential reading. Encrypted entries are decrypted with the password of
+// passwordFunc. The data is verified with CRC32 or the authentication code at the end.
+func openEntry(f *zip.File, passwordFunc func(name string) (string, error)) (io.ReadCloser, error) {
+	if !isEncrypted(f) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		return newCRCReader(rc, f.Name, f.CRC32), nil
+	}
+	if f.Method != zipMethodAES {
+		return nil, errors.Wrapf(ErrUnsupportedEncryption, "entry '%s'", f.Name)
+	}
+	ae, err := parseAESExtra(f.Extra)
+	if err != nil {
+		return nil, errors.Wrapf(err, "entry '%s'", f.Name)
+	}
+	if passwordFunc == nil {
+		return nil, errors.Wrapf(ErrPasswordRequired, "entry '%s'", f.Name)
+	}
+	password, err := passwordFunc(f.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get password of '%s'", f.Name)
+	}
+	if password == "" {
+		return nil, errors.Wrapf(ErrPasswordRequired, "entry '%s'", f.Name)
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open raw data of '%s'", f.Name)
+	}
+	ar, err := newAESReader(raw, int64(f.CompressedSize64), ae, password, f.Name)
+	if err != nil {
+		return nil, err
+	}
+	var rc io.ReadCloser
+	switch ae.method {
+	case zip.Store:
+		rc = io.NopCloser(ar)
+	case zip.Deflate:
+		rc = &aesDeflateReader{ReadCloser: flate.NewReader(ar), ar: ar}
+	default:
+		return nil, errors.Wrapf(zip.ErrAlgorithm, "entry '%s' uses method %d", f.Name, ae.method)
+	}
+	// AE-2 does not store the CRC32
+	if ae.version == 1 {
+		return newCRCReader(rc, f.Name, f.CRC32), nil
+	}
+	return rc, nil
+}
+
+// aesReader decrypts WinZip AES data with little endian counter mode and checks the authentication code at the end
+type aesReader struct {
+	raw       io.Reader
+	remaining int64
+	block     cipher.Block
+	counter   [aes.BlockSize]byte
+	stream    [aes.BlockSize]byte
+	used      int
+	mac       hash.Hash
+	name      string
+	verified  bool
+}
+
+func newAESReader(raw io.Reader, size int64, ae *aesExtra, password, name string) (*aesReader, error) {
+	salt := make([]byte, ae.saltSize()+aesVerifierSize)
+	if _, err := io.ReadFull(raw, salt); err != nil {
+		return nil, errors.Wrapf(err, "cannot read salt of '%s'", name)
+	}
+	keySize := ae.keySize()
+	keys, err := pbkdf2.Key(sha1.New, password, salt[:ae.saltSize()], 1000, 2*keySize+aesVerifierSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot derive keys")
+	}
+	if subtle.ConstantTimeCompare(keys[2*keySize:], salt[ae.saltSize():]) != 1 {
+		return nil, errors.Wrapf(ErrInvalidPassword, "entry '%s'", name)
+	}
+	block, err := aes.NewCipher(keys[:keySize])
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create cipher")
+	}
+	remaining := size - int64(len(salt)) - aesAuthCodeSize
+	if remaining < 0 {
+		return nil, errors.Errorf("invalid size of encrypted entry '%s'", name)
+	}
+	return &aesReader{
+		raw:       raw,
+		remaining: remaining,
+		block:     block,
+		used:      aes.BlockSize,
+		mac:       hmac.New(sha1.New, keys[keySize:2*keySize]),
+		name:      name,
+	}, nil
+}
+
+func (ar *aesReader) Read(p []byte) (int, error) {
+	if ar.remaining <= 0 {
+		return 0, ar.verify()
+	}
+	if int64(len(p)) > ar.remaining {
+		p = p[:ar.remaining]
+	}
+	n, err := ar.raw.Read(p)
+	ar.remaining -= int64(n)
+	ar.mac.Write(p[:n])
+	for i := 0; i < n; i++ {
+		if ar.used == aes.BlockSize {
+			// little endian counter starting with 1
+			for j := range ar.counter {
+				ar.counter[j]++
+				if ar.counter[j] != 0 {
+					break
+				}
+			}
+			ar.block.Encrypt(ar.stream[:], ar.counter[:])
+			ar.used = 0
+		}
+		p[i] ^= ar.stream[ar.used]
+		ar.used++
+	}
+	if err == io.EOF {
+		if ar.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// verify checks the authentication code after the encrypted data
+func (ar *aesReader) verify() error {
+	if ar.verified {
+		return io.EOF
+	}
+	var code = make([]byte, aesAuthCodeSize)
+	if _, err := io.ReadFull(ar.raw, code); err != nil {
+		return errors.Wrapf(err, "cannot read authentication code of '%s'", ar.name)
+	}
+	if !hmac.Equal(code, ar.mac.Sum(nil)[:aesAuthCodeSize]) {
+		return &AuthenticationError{Name: ar.name}
+	}
+	ar.verified = true
+	return io.EOF
+}
+
+// aesDeflateReader reads the rest of the encrypted data after the end of the deflate stream to check the authentication code
+type aesDeflateReader struct {
+	io.ReadCloser
+	ar *aesReader
+}
+
+func (adr *aesDeflateReader) Read(p []byte) (int, error) {
+	n, err := adr.ReadCloser.Read(p)
+	if err == io.EOF {
+		if _, err := io.Copy(io.Discard, adr.ar); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// crcReader computes the CRC32 of the data and returns a ChecksumError at the end if it does not match.
+// A CRC32 of 0 is not checked, like in archive/zip
+type crcReader struct {
+	rc       io.ReadCloser
+	name     string
+	expected uint32
+	hash     hash.Hash32
+}
+
+func newCRCReader(rc io.ReadCloser, name string, expected uint32) *crcReader {
+	return &crcReader{
+		rc:       rc,
+		name:     name,
+		expected: expected,
+		hash:     crc32.NewIEEE(),
+	}
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.hash.Write(p[:n])
+	if errors.Is(err, zip.ErrChecksum) || (err == io.EOF && cr.expected != 0 && cr.hash.Sum32() != cr.expected) {
+		return n, &ChecksumError{Name: cr.name, Expected: cr.expected, Actual: cr.hash.Sum32()}
+	}
+	return n, err
+}
+
+func (cr *crcReader) Close() error {
+	return cr.rc.Close()
+}
@@ -0,0 +1,200 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"testing"
+
+	"emperror.dev/errors"
+)
+
+// createAESZIP creates a zip file with a deflated WinZip AE-2 entry using AES-256
+func createAESZIP(t *testing.T, name string, content []byte, password string) []byte {
+	t.Helper()
+	compressed := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(compressed, flate.BestCompression)
+	fw.Write(content)
+	fw.Close()
+
+	salt := bytes.Repeat([]byte{0x42}, 16)
+	keys, err := pbkdf2.Key(sha1.New, password, salt, 1000, 2*32+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := compressed.Bytes()
+	var counter, stream [aes.BlockSize]byte
+	for i := range encrypted {
+		if i%aes.BlockSize == 0 {
+			binary.LittleEndian.PutUint64(counter[:], uint64(i/aes.BlockSize+1))
+			block.Encrypt(stream[:], counter[:])
+		}
+		encrypted[i] ^= stream[i%aes.BlockSize]
+	}
+	mac := hmac.New(sha1.New, keys[32:64])
+	mac.Write(encrypted)
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zipMethodAES,
+		Flags:              zipFlagEncrypt,
+		Extra:              []byte{0x01, 0x99, 7, 0, 2, 0, 'A', 'E', 3, 8, 0},
+		CompressedSize64:   uint64(len(salt) + 2 + len(encrypted) + 10),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(salt)
+	w.Write(keys[64:])
+	w.Write(encrypted)
+	w.Write(mac.Sum(nil)[:10])
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptedEntries(t *testing.T) {
+	content := bytes.Repeat([]byte("secret content "), 100)
+	data := createAESZIP(t, "secret.txt", content, "geheim")
+	tampered := bytes.Clone(data)
+	tampered[bytes.Index(tampered, []byte("secret.txt"))+len("secret.txt")+40] ^= 0xff
+
+	mfs := NewMemFS()
+	mfs.WriteFile("aes.zip", data)
+	mfs.WriteFile("tampered.zip", tampered)
+	mfs.WriteFile("other.zip", data)
+
+	var passwords = map[string]string{"aes.zip": "geheim", "tampered.zip": "geheim", "other.zip": "falsch"}
+	opts := DefaultOptions(5)
+	opts.PasswordFunc = func(archive, name string) (string, error) {
+		return passwords[archive], nil
+	}
+	fsys, err := NewFSWithOptions(mfs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+
+	result, err := fs.ReadFile(fsys, "aes.zip/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, content) {
+		t.Error("invalid decrypted content")
+	}
+	// random access via spool file
+	fp, err := fsys.Open("aes.zip/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var part = make([]byte, 6)
+	if _, err := fp.(io.ReaderAt).ReadAt(part, 15); err != nil || string(part) != "secret" {
+		t.Errorf("invalid ReadAt: %q %v", part, err)
+	}
+	fp.Close()
+
+	if _, err := fs.ReadFile(fsys, "other.zip/secret.txt"); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("expected ErrInvalidPassword, got %v", err)
+	}
+	var authErr *AuthenticationError
+	if _, err := fs.ReadFile(fsys, "tampered.zip/secret.txt"); !errors.As(err, &authErr) {
+		t.Errorf("expected AuthenticationError, got %v", err)
+	}
+
+	noPassword, _ := NewFSWithOptions(mfs, DefaultOptions(5))
+	defer noPassword.Close()
+	if _, err := fs.ReadFile(noPassword, "aes.zip/secret.txt"); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired, got %v", err)
+	}
+}
+
+// corruptZIP replaces the first occurrence of old in data
+func corruptZIP(data []byte, old, new string) []byte {
+	return bytes.Replace(bytes.Clone(data), []byte(old), []byte(new), 1)
+}
+
+func TestChecksumAndVerify(t *testing.T) {
+	stored := createZIP(t, zip.Store, map[string][]byte{"a.txt": []byte("original content")})
+	deflated := createZIP(t, zip.Deflate, map[string][]byte{"b.txt": bytes.Repeat([]byte("b"), 1000)})
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"dir/c.txt", "dir/c.txt", "../evil.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(name))
+	}
+	// central directory and local header disagree about the crc32
+	w, _ := zw.CreateRaw(&zip.FileHeader{Name: "d.txt", Method: zip.Store, CRC32: crc32.ChecksumIEEE([]byte("d")), CompressedSize64: 1, UncompressedSize64: 1})
+	w.Write([]byte("d"))
+	zw.Close()
+	broken := buf.Bytes()
+	localCRC := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte("d")))
+	broken = bytes.Replace(broken, append(localCRC, 1, 0, 0, 0), append([]byte{0, 0, 0, 0}, 1, 0, 0, 0), 1)
+
+	mfs := NewMemFS()
+	mfs.WriteFile("stored.zip", corruptZIP(stored, "original", "modified"))
+	mfs.WriteFile("deflated.zip", deflated)
+	mfs.WriteFile("broken.zip", broken)
+	fsys, _ := NewFSWithOptions(mfs, DefaultOptions(5))
+	defer fsys.Close()
+
+	var checksumErr *ChecksumError
+	if _, err := fs.ReadFile(fsys, "stored.zip/a.txt"); !errors.As(err, &checksumErr) {
+		t.Fatalf("expected ChecksumError, got %v", err)
+	}
+	if checksumErr.Name != "a.txt" || checksumErr.Expected != crc32.ChecksumIEEE([]byte("original content")) {
+		t.Errorf("invalid checksum error %v", checksumErr)
+	}
+
+	report, err := fsys.Verify("deflated.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Entries != 1 {
+		t.Errorf("unexpected problems %v", report.Problems)
+	}
+	report, err = fsys.Verify("stored.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !errors.As(report.Problems[0].Err, &checksumErr) {
+		t.Errorf("expected checksum problem, got %v", report.Problems)
+	}
+
+	report, err = fsys.Verify("broken.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds = map[ProblemKind][]string{}
+	for _, problem := range report.Problems {
+		kinds[problem.Kind] = append(kinds[problem.Kind], problem.Name)
+	}
+	if len(kinds[ProblemDuplicate]) != 1 || kinds[ProblemDuplicate][0] != "dir/c.txt" {
+		t.Errorf("duplicate not found: %v", report.Problems)
+	}
+	if len(kinds[ProblemPathTraversal]) != 1 || kinds[ProblemPathTraversal][0] != "../evil.txt" {
+		t.Errorf("path traversal not found: %v", report.Problems)
+	}
+	if len(kinds[ProblemHeaderMismatch]) != 1 || kinds[ProblemHeaderMismatch][0] != "d.txt" {
+		t.Errorf("header mismatch not found: %v", report.Problems)
+	}
+	if len(kinds[ProblemData]) != 0 {
+		t.Errorf("unexpected data problems: %v", report.Problems)
+	}
+}
@@ -0,0 +1,249 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"sync"
+
+	"emperror.dev/errors"
+)
+
+// DecompressForSeek enables random access to compressed zip entries. On the first Seek or ReadAt,
+// which cannot be served by sequential reading, the entry is decompressed to a spool file.
+// The spool file is cached until the archive is closed.
+var DecompressForSeek = true
+
+// zipEntryReader gives random access to a zip entry. Stored entries are read directly from the archive.
+// Compressed entries are streamed as long as they are read sequentially. ReadAt may be called concurrently.
+type zipEntryReader struct {
+	zipFS *ZIPFS
+	file  *zip.File
+	size  int64
+	pos   int64
+	// sequential reader of a compressed entry and its position
+	rc        io.ReadCloser
+	streamPos int64
+	// random access to the entry data
+	data *ArchiveFile
+	// reference to the archive for the sequential reader
+	zipFile *ArchiveFile
+	// CRC32 of stored entries as long as they are read sequentially from the start
+	crc    hash.Hash32
+	crcPos int64
+	closed bool
+	// protects the switch to random access and closed
+	lock sync.Mutex
+}
+
+func newZIPEntryReader(zipFS *ZIPFS, file *zip.File) (*zipEntryReader, error) {
+	zer := &zipEntryReader{
+		zipFS: zipFS,
+		file:  file,
+		size:  int64(file.UncompressedSize64),
+	}
+	if file.Method == zip.Store && !isEncrypted(file) {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get data offset of '%s'", file.Name)
+		}
+		zer.data = zipFS.zipFile.Section(offset, zer.size)
+		if file.CRC32 != 0 {
+			zer.crc = crc32.NewIEEE()
+		}
+		return zer, nil
+	}
+	rc, err := openEntry(file, zipFS.passwordFunc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open '%s'", file.Name)
+	}
+	zer.rc = rc
+	zer.zipFile = zipFS.zipFile.Acquire()
+	return zer, nil
+}
+
+// random switches a compressed entry to the decompressed spool file. The caller holds lock
+func (zer *zipEntryReader) random() error {
+	if zer.data != nil {
+		return nil
+	}
+	if !DecompressForSeek {
+		return errors.Errorf("random access to compressed entry '%s' is disabled", zer.file.Name)
+	}
+	data, err := zer.zipFS.decompressed(zer.file)
+	if err != nil {
+		return err
+	}
+	zer.data = data
+	if zer.rc != nil {
+		zer.rc.Close()
+		zer.rc = nil
+		zer.zipFile.Release()
+		zer.zipFile = nil
+	}
+	return nil
+}
+
+func (zer *zipEntryReader) Read(p []byte) (int, error) {
+	zer.lock.Lock()
+	defer zer.lock.Unlock()
+	if zer.closed {
+		return 0, fs.ErrClosed
+	}
+	if zer.data == nil && zer.pos == zer.streamPos {
+		n, err := zer.rc.Read(p)
+		zer.pos += int64(n)
+		zer.streamPos += int64(n)
+		return n, err
+	}
+	if zer.pos >= zer.size {
+		return 0, io.EOF
+	}
+	if err := zer.random(); err != nil {
+		return 0, err
+	}
+	n, err := zer.data.ReadAt(p[:min(int64(len(p)), zer.size-zer.pos)], zer.pos)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if crcErr := zer.checkCRC(p[:n]); crcErr != nil {
+		err = crcErr
+	}
+	zer.pos += int64(n)
+	return n, err
+}
+
+// checkCRC updates the CRC32 with data read at the current position. Reads which are not
+// sequential from the start disable the check
+func (zer *zipEntryReader) checkCRC(data []byte) error {
+	if zer.crc == nil {
+		return nil
+	}
+	if zer.pos != zer.crcPos {
+		zer.crc = nil
+		return nil
+	}
+	zer.crc.Write(data)
+	zer.crcPos += int64(len(data))
+	if zer.crcPos == zer.size && zer.crc.Sum32() != zer.file.CRC32 {
+		return &ChecksumError{Name: zer.file.Name, Expected: zer.file.CRC32, Actual: zer.crc.Sum32()}
+	}
+	return nil
+}
+
+func (zer *zipEntryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += zer.pos
+	case io.SeekEnd:
+		offset += zer.size
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	zer.pos = offset
+	return offset, nil
+}
+
+func (zer *zipEntryReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	zer.lock.Lock()
+	if zer.closed {
+		zer.lock.Unlock()
+		return 0, fs.ErrClosed
+	}
+	if err := zer.random(); err != nil {
+		zer.lock.Unlock()
+		return 0, err
+	}
+	data := zer.data
+	zer.lock.Unlock()
+	if off >= zer.size {
+		return 0, io.EOF
+	}
+	n, err := data.ReadAt(p[:min(int64(len(p)), zer.size-off)], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close releases the references to the archive. Further calls return fs.ErrClosed
+func (zer *zipEntryReader) Close() error {
+	zer.lock.Lock()
+	defer zer.lock.Unlock()
+	if zer.closed {
+		return fs.ErrClosed
+	}
+	zer.closed = true
+	var errs []error
+	if zer.rc != nil {
+		errs = append(errs, zer.rc.Close(), zer.zipFile.Release())
+		zer.rc = nil
+		zer.zipFile = nil
+	}
+	if zer.data != nil {
+		errs = append(errs, zer.data.Release())
+		zer.data = nil
+	}
+	return errors.Combine(errs...)
+}
+
+// decompressedCache holds the spool files of compressed entries used for random access
+type decompressedCache struct {
+	files  map[*zip.File]*ArchiveFile
+	lock   sync.Mutex
+	closed bool
+}
+
+// decompressed returns a reference to the decompressed data of file
+func (zipFS *ZIPFS) decompressed(file *zip.File) (*ArchiveFile, error) {
+	zipFS.decompressedCache.lock.Lock()
+	defer zipFS.decompressedCache.lock.Unlock()
+	if data, ok := zipFS.decompressedCache.files[file]; ok {
+		return data.Acquire(), nil
+	}
+	rc, err := openEntry(file, zipFS.passwordFunc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open '%s'", file.Name)
+	}
+	defer rc.Close()
+	data, err := spoolFile(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decompress '%s'", file.Name)
+	}
+	if zipFS.decompressedCache.closed {
+		// archive is closed, the data is not cached anymore
+		return data, nil
+	}
+	if zipFS.decompressedCache.files == nil {
+		zipFS.decompressedCache.files = map[*zip.File]*ArchiveFile{}
+	}
+	zipFS.decompressedCache.files[file] = data
+	return data.Acquire(), nil
+}
+
+// release removes the references of the cache
+func (dc *decompressedCache) release() error {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	var errs []error
+	for _, data := range dc.files {
+		errs = append(errs, data.Release())
+	}
+	dc.files = nil
+	dc.closed = true
+	return errors.Combine(errs...)
+}
+
+var (
+	_ io.ReadSeekCloser = &zipEntryReader{}
+	_ io.ReaderAt       = &zipEntryReader{}
+)
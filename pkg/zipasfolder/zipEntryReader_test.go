@@ -0,0 +1,129 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestZIPEntrySeek(t *testing.T) {
+	var content = bytes.Repeat([]byte("0123456789"), 10000)
+	dir := t.TempDir()
+	for name, method := range map[string]uint16{"stored.zip": zip.Store, "deflated.zip": zip.Deflate} {
+		data := createZIP(t, method, map[string][]byte{"data.bin": content})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := NewFS(NewDummyOSRW(dir), 10)
+	defer fsys.(FSRWClose).Close()
+	for _, name := range []string{"stored.zip/data.bin", "deflated.zip/data.bin"} {
+		fp, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, ok := fp.(io.ReadSeeker)
+		if !ok {
+			t.Fatalf("'%s' is not an io.ReadSeeker", name)
+		}
+		size, err := rs.Seek(0, io.SeekEnd)
+		if err != nil || size != int64(len(content)) {
+			t.Fatalf("%s: invalid size %d: %v", name, size, err)
+		}
+		if _, err := rs.Seek(12345, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		if _, err := io.ReadFull(rs, buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(buf, content[12345:12445]) {
+			t.Errorf("%s: invalid data after seek", name)
+		}
+		ra, ok := fp.(io.ReaderAt)
+		if !ok {
+			t.Fatalf("'%s' is not an io.ReaderAt", name)
+		}
+		n, err := ra.ReadAt(buf, int64(len(content)-50))
+		if n != 50 || err != io.EOF {
+			t.Errorf("%s: ReadAt at end returned %d, %v", name, n, err)
+		}
+		if !bytes.Equal(buf[:n], content[len(content)-50:]) {
+			t.Errorf("%s: invalid data of ReadAt", name)
+		}
+		if err := fp.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestZIPEntryDoubleClose(t *testing.T) {
+	dir := t.TempDir()
+	for name, method := range map[string]uint16{"stored.zip": zip.Store, "deflated.zip": zip.Deflate} {
+		data := createZIP(t, method, map[string][]byte{"a": []byte("entry a"), "b": []byte("entry b")})
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := NewFS(NewDummyOSRW(dir), 10)
+	defer fsys.(FSRWClose).Close()
+	for _, archive := range []string{"stored.zip", "deflated.zip"} {
+		fp, err := fsys.Open(archive + "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := fp.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("%s: second close returned %v", archive, err)
+		}
+		// the second close must not release the shared archive
+		data, err := fs.ReadFile(fsys, archive+"/b")
+		if err != nil {
+			t.Fatalf("%s: cannot read b after double close of a: %v", archive, err)
+		}
+		if string(data) != "entry b" {
+			t.Errorf("%s: invalid content of b: %s", archive, data)
+		}
+	}
+}
+
+func TestZIPEntryConcurrentReadAt(t *testing.T) {
+	var content = bytes.Repeat([]byte("0123456789"), 10000)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "deflated.zip"), createZIP(t, zip.Deflate, map[string][]byte{"data.bin": content}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFS(NewDummyOSRW(dir), 10)
+	defer fsys.(FSRWClose).Close()
+	fp, err := fsys.Open("deflated.zip/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	ra := fp.(io.ReaderAt)
+	// the first ReadAt calls switch to random access concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 100)
+			if _, err := ra.ReadAt(buf, off); err != nil {
+				t.Errorf("ReadAt %d: %v", off, err)
+				return
+			}
+			if !bytes.Equal(buf, content[off:off+100]) {
+				t.Errorf("invalid data at %d", off)
+			}
+		}(int64(i) * 1000)
+	}
+	wg.Wait()
+}
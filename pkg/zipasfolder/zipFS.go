@@ -2,93 +2,157 @@ package zipasfolder
 
 import (
 	"archive/zip"
-	"github.com/pkg/errors"
-	"golang.org/x/exp/slices"
+	"emperror.dev/errors"
+	"io"
 	"io/fs"
-	"strings"
+	"sync/atomic"
 )
 
-func NewZIPFS(zipReader *zip.Reader, zipFile fs.File) *ZIPFS {
+func init() {
+	if err := RegisterArchiveOpener(".zip", openZIP); err != nil {
+		panic(err)
+	}
+}
+
+func openZIP(file *ArchiveFile) (ArchiveFS, error) {
+	zipReader, err := zip.NewReader(file, file.Size())
+	if err != nil {
+		file.Release()
+		return nil, errors.Wrap(err, "cannot create zip reader")
+	}
+	return NewZIPFS(zipReader, file), nil
+}
+
+func NewZIPFS(zipReader *zip.Reader, zipFile *ArchiveFile) *ZIPFS {
 	return &ZIPFS{
 		zipReader: zipReader,
 		zipFile:   zipFile,
-		lock:      NewMutex(),
+		index:     newZIPIndex(zipReader.File),
 	}
 }
 
 type ZIPFS struct {
 	zipReader *zip.Reader
-	zipFile   fs.File
-	lock      *Mutex
+	zipFile   *ArchiveFile
+	index     *zipNode
+	// number of open files
+	open atomic.Int64
+	// decompressed entries for random access
+	decompressedCache decompressedCache
+	// password of encrypted entries
+	passwordFunc func(name string) (string, error)
+}
+
+// SetPasswordFunc sets the password provider for encrypted entries
+func (zipFS *ZIPFS) SetPasswordFunc(passwordFunc func(name string) (string, error)) {
+	zipFS.passwordFunc = passwordFunc
 }
 
 func (zipFS *ZIPFS) Stat(name string) (fs.FileInfo, error) {
-	zipFS.lock.Lock()
-	defer zipFS.lock.Unlock()
-	for _, f := range zipFS.zipReader.File {
-		if f.Name == name {
-			_, err := f.Open()
-			if err != nil {
-
-				return nil, errors.WithStack(err)
-			}
-			return f.FileInfo(), nil
-		}
+	node, ok := zipFS.index.lookup(name)
+	if !ok {
+		return nil, fs.ErrNotExist
 	}
-	return nil, fs.ErrNotExist
+	return node.info(), nil
 }
 
 func (zipFS *ZIPFS) ReadDir(name string) ([]fs.DirEntry, error) {
-	zipFS.lock.Lock()
-	defer zipFS.lock.Unlock()
-	if name == "." {
-		name = ""
+	node, ok := zipFS.index.lookup(name)
+	if !ok {
+		return nil, fs.ErrNotExist
 	}
-	name = strings.Trim(name, "/")
-	var result []fs.DirEntry
-	for _, f := range zipFS.zipReader.File {
-		if strings.HasPrefix(f.Name, name) {
-			parts := strings.Split(strings.Trim(f.Name[len(name):], "/"), "/")
-			if len(parts) == 1 {
-				result = append(result, NewZIPFSDirEntry(f.FileInfo()))
-				continue
-			}
-			result = append(result, NewZIPFSDirEntry(NewZIPFSFileInfoDir(parts[0])))
-		}
+	if !node.isDir() {
+		return nil, errors.Errorf("'%s' is not a directory", name)
 	}
-	slices.SortFunc(result, func(i, j fs.DirEntry) bool {
-		return i.Name() < j.Name()
-	})
-	return slices.CompactFunc(result, func(i, j fs.DirEntry) bool {
-		return i.Name() == j.Name()
-	}), nil
+	var result = make([]fs.DirEntry, 0, len(node.names))
+	for _, n := range node.names {
+		result = append(result, NewZIPFSDirEntry(node.children[n].info()))
+	}
+	return result, nil
 }
 
+// IsLocked reports whether files of the archive are open
 func (zipFS *ZIPFS) IsLocked() bool {
-	return zipFS.lock.IsLocked()
+	return zipFS.open.Load() > 0
 }
 
+// Close releases the archive. Open files keep it alive until they are closed
 func (zipFS *ZIPFS) Close() error {
-	return errors.WithStack(zipFS.zipFile.Close())
+	return errors.WithStack(errors.Combine(zipFS.decompressedCache.release(), zipFS.zipFile.Release()))
+}
+
+// RawFile returns the data of name if it is stored without compression
+func (zipFS *ZIPFS) RawFile(name string) (*ArchiveFile, bool, error) {
+	node, ok := zipFS.index.lookup(name)
+	if !ok || node.file == nil {
+		return nil, false, fs.ErrNotExist
+	}
+	if node.file.Method != zip.Store || isEncrypted(node.file) {
+		return nil, false, nil
+	}
+	offset, err := node.file.DataOffset()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	return zipFS.zipFile.Section(offset, int64(node.file.CompressedSize64)), true, nil
 }
 
 func (zipFS *ZIPFS) Open(name string) (fs.File, error) {
-	zipFS.lock.Lock()
-	for _, f := range zipFS.zipReader.File {
-		if f.Name == name {
-			rc, err := f.Open()
-			if err != nil {
-				zipFS.lock.Unlock()
-				return nil, errors.WithStack(err)
-			}
-			return NewFile(f.FileInfo(), rc, zipFS.lock), nil
+	node, ok := zipFS.index.lookup(name)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	// directories may have an entry of their own
+	if node.isDir() {
+		return &zipDir{node: node}, nil
+	}
+	rc, err := newZIPEntryReader(zipFS, node.file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	zipFS.open.Add(1)
+	return NewFile(node.info(), rc, func() { zipFS.open.Add(-1) }), nil
+}
+
+// zipDir is an opened directory of the archive
+type zipDir struct {
+	node   *zipNode
+	offset int
+}
+
+func (zd *zipDir) Stat() (fs.FileInfo, error) {
+	return zd.node.info(), nil
+}
+
+func (zd *zipDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: zd.node.name, Err: fs.ErrInvalid}
+}
+
+func (zd *zipDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := zd.node.names[min(zd.offset, len(zd.node.names)):]
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
 		}
+		names = names[:min(n, len(names))]
 	}
-	return nil, fs.ErrNotExist
+	zd.offset += len(names)
+	var result = make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		result = append(result, NewZIPFSDirEntry(zd.node.children[name].info()))
+	}
+	return result, nil
+}
+
+func (zd *zipDir) Close() error {
+	return nil
 }
 
 var (
-	_ fs.FS        = &ZIPFS{}
-	_ fs.ReadDirFS = &ZIPFS{}
-	_ fs.StatFS    = &ZIPFS{}
+	_ ArchiveFS      = &ZIPFS{}
+	_ ArchiveFSRaw   = &ZIPFS{}
+	_ fs.FS          = &ZIPFS{}
+	_ fs.ReadDirFS   = &ZIPFS{}
+	_ fs.StatFS      = &ZIPFS{}
+	_ fs.ReadDirFile = &zipDir{}
 )
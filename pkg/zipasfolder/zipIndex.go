@@ -0,0 +1,94 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// zipNode is a file or directory within the directory tree of a zip archive.
+// Directories without an entry of their own have no file.
+type zipNode struct {
+	name     string
+	file     *zip.File
+	children map[string]*zipNode
+	// sorted names of the children
+	names []string
+}
+
+func (node *zipNode) isDir() bool {
+	return node.children != nil
+}
+
+func (node *zipNode) info() fs.FileInfo {
+	if node.isDir() && (node.file == nil || !node.file.FileInfo().IsDir()) {
+		return NewZIPFSFileInfoDir(node.name)
+	}
+	return node.file.FileInfo()
+}
+
+// newZIPIndex builds the directory tree of all entries. Parent directories missing in the archive are synthesized.
+// If an entry appears more than once, the first one is used.
+func newZIPIndex(files []*zip.File) *zipNode {
+	root := &zipNode{name: ".", children: map[string]*zipNode{}}
+	for _, f := range files {
+		name := strings.Trim(path.Clean("/"+f.Name), "/")
+		if name == "" {
+			continue
+		}
+		isDir := strings.HasSuffix(f.Name, "/")
+		parent := root
+		parts := strings.Split(name, "/")
+		for i, part := range parts {
+			child, ok := parent.children[part]
+			if !ok {
+				child = &zipNode{name: part}
+				parent.children[part] = child
+			}
+			last := i == len(parts)-1
+			if !last || isDir {
+				if child.children == nil {
+					child.children = map[string]*zipNode{}
+				}
+			}
+			if last && child.file == nil {
+				child.file = f
+			}
+			parent = child
+		}
+	}
+	root.sortNames()
+	return root
+}
+
+func (node *zipNode) sortNames() {
+	if node.children == nil {
+		return
+	}
+	node.names = make([]string, 0, len(node.children))
+	for name, child := range node.children {
+		node.names = append(node.names, name)
+		child.sortNames()
+	}
+	slices.Sort(node.names)
+}
+
+// lookup returns the node of name
+func (node *zipNode) lookup(name string) (*zipNode, bool) {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return node, true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if node.children == nil {
+			return nil, false
+		}
+		var ok bool
+		if node, ok = node.children[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
@@ -0,0 +1,93 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestZIPIndex(t *testing.T) {
+	data := createZIP(t, zip.Deflate, map[string][]byte{
+		"foo/":           nil,
+		"foo/a.txt":      []byte("a"),
+		"foobar/b.txt":   []byte("b"),
+		"x/y/z/deep.txt": []byte("deep"),
+		"empty/":         nil,
+	})
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipFS := NewZIPFS(zr, NewArchiveFile(bytes.NewReader(data), int64(len(data)), nil))
+	defer zipFS.Close()
+
+	var names = func(dir string) []string {
+		entries, err := zipFS.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("cannot read dir '%s': %v", dir, err)
+		}
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Name())
+		}
+		return result
+	}
+	if n := names("."); !slices.Equal(n, []string{"empty", "foo", "foobar", "x"}) {
+		t.Errorf("invalid root entries: %v", n)
+	}
+	if n := names("foo"); !slices.Equal(n, []string{"a.txt"}) {
+		t.Errorf("invalid entries of 'foo': %v", n)
+	}
+	if n := names("x/y"); !slices.Equal(n, []string{"z"}) {
+		t.Errorf("invalid entries of 'x/y': %v", n)
+	}
+	if n := names("empty"); len(n) != 0 {
+		t.Errorf("invalid entries of 'empty': %v", n)
+	}
+	for _, dir := range []string{"x", "x/y/z", "empty"} {
+		info, err := zipFS.Stat(dir)
+		if err != nil {
+			t.Fatalf("cannot stat '%s': %v", dir, err)
+		}
+		if !info.IsDir() {
+			t.Errorf("'%s' is not a directory", dir)
+		}
+	}
+	if _, err := zipFS.Stat("fo"); err != fs.ErrNotExist {
+		t.Errorf("stat of 'fo' returned %v", err)
+	}
+	if _, err := zipFS.ReadDir("foo/a.txt"); err == nil {
+		t.Error("ReadDir of a file did not fail")
+	}
+	fp, err := zipFS.Open("x/y/z/deep.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(fp)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "deep" {
+		t.Errorf("invalid content '%s'", content)
+	}
+	// directories with and without an entry of their own
+	for _, dir := range []string{"foo", "x"} {
+		fp, err := zipFS.Open(dir)
+		if err != nil {
+			t.Fatalf("cannot open '%s': %v", dir, err)
+		}
+		rdf, ok := fp.(fs.ReadDirFile)
+		if !ok {
+			t.Fatalf("'%s' is not an fs.ReadDirFile", dir)
+		}
+		entries, err := rdf.ReadDir(-1)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("invalid entries of '%s': %v, %v", dir, entries, err)
+		}
+		fp.Close()
+	}
+}
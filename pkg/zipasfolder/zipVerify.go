@@ -0,0 +1,186 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+// ProblemKind classifies the problems found by Verify
+type ProblemKind string
+
+const (
+	ProblemDuplicate      ProblemKind = "duplicate"
+	ProblemPathTraversal  ProblemKind = "path traversal"
+	ProblemHeaderMismatch ProblemKind = "header mismatch"
+	ProblemData           ProblemKind = "data"
+)
+
+// VerifyProblem is a problem of an entry found by Verify
+type VerifyProblem struct {
+	Name    string
+	Kind    ProblemKind
+	Message string
+	// Err is the error of ProblemData, e.g. a *ChecksumError
+	Err error
+}
+
+func (vp VerifyProblem) String() string {
+	return fmt.Sprintf("%s: %s: %s", vp.Name, vp.Kind, vp.Message)
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Archive  string
+	Entries  int
+	Problems []VerifyProblem
+}
+
+// OK reports whether no problems were found
+func (vr *VerifyReport) OK() bool {
+	return len(vr.Problems) == 0
+}
+
+func (vr *VerifyReport) add(name string, kind ProblemKind, err error, format string, args ...any) {
+	vr.Problems = append(vr.Problems, VerifyProblem{Name: name, Kind: kind, Message: fmt.Sprintf(format, args...), Err: err})
+}
+
+// Verify checks the zip file zipFile like fsck. Every entry is read completely and checked against its CRC32
+// or authentication code. Duplicate names, names leaving the archive and inconsistencies between central directory
+// and local headers are reported as well.
+func (fsys *FS) Verify(zipFile string) (*VerifyReport, error) {
+	zipFile = strings.Trim(strings.TrimPrefix(zipFile, "./"), "/")
+	archiveFS, release, err := fsys.getArchive(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	zipFS, ok := archiveFS.(*ZIPFS)
+	if !ok {
+		return nil, errors.Errorf("'%s' is not a zip file", zipFile)
+	}
+	report, err := VerifyZIP(zipFS.zipReader, zipFS.zipFile, zipFS.passwordFunc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot verify '%s'", zipFile)
+	}
+	report.Archive = zipFile
+	return report, nil
+}
+
+// VerifyZIP checks all entries of zipReader. data is the zip file zipReader reads from.
+func VerifyZIP(zipReader *zip.Reader, data *ArchiveFile, passwordFunc func(name string) (string, error)) (*VerifyReport, error) {
+	report := &VerifyReport{Entries: len(zipReader.File)}
+	var names = map[string]int{}
+	for _, f := range zipReader.File {
+		name := strings.TrimSuffix(strings.ReplaceAll(f.Name, "\\", "/"), "/")
+		if isTraversal(name) {
+			report.add(f.Name, ProblemPathTraversal, nil, "name leaves the archive")
+		}
+		names[path.Clean("/"+name)]++
+		if names[path.Clean("/"+name)] == 2 {
+			report.add(f.Name, ProblemDuplicate, nil, "name is used more than once")
+		}
+		if err := verifyLocalHeader(f, data, report); err != nil {
+			return nil, err
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := openEntry(f, passwordFunc)
+		if err != nil {
+			report.add(f.Name, ProblemData, err, "cannot open: %v", err)
+			continue
+		}
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			report.add(f.Name, ProblemData, err, "cannot read: %v", err)
+		}
+		rc.Close()
+	}
+	return report, nil
+}
+
+// isTraversal reports whether name is absolute or contains ".."
+func isTraversal(name string) bool {
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	zipLocalHeaderSignature = 0x04034b50
+	zipLocalHeaderSize      = 30
+	zipFlagDataDescriptor   = 0x8
+	zip64Marker             = 0xffffffff
+)
+
+// verifyLocalHeader compares the local header of f with the central directory. Only read errors are returned
+func verifyLocalHeader(f *zip.File, data *ArchiveFile, report *VerifyReport) error {
+	offset, err := f.DataOffset()
+	if err != nil {
+		report.add(f.Name, ProblemHeaderMismatch, err, "invalid local header: %v", err)
+		return nil
+	}
+	if offset+int64(f.CompressedSize64) > data.Size() {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "data exceeds archive size")
+	}
+	// the local header ends with name and extra field, whose size may differ from the central directory
+	var header []byte
+	for _, maxExtra := range []int{1024, 0xffff} {
+		size := min(offset, int64(zipLocalHeaderSize+len(f.Name)+maxExtra))
+		buf := make([]byte, size)
+		if _, err := data.ReadAt(buf, offset-size); err != nil {
+			return errors.Wrapf(err, "cannot read local header of '%s'", f.Name)
+		}
+		if header = findLocalHeader(buf, len(f.Name)); header != nil || int64(len(buf)) == offset {
+			break
+		}
+	}
+	if header == nil {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local header not found")
+		return nil
+	}
+	if localName := string(header[zipLocalHeaderSize : zipLocalHeaderSize+len(f.Name)]); localName != f.Name {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local name '%s' differs", localName)
+	}
+	if method := binary.LittleEndian.Uint16(header[8:]); method != f.Method {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local method %d differs from %d", method, f.Method)
+	}
+	flags := binary.LittleEndian.Uint16(header[6:])
+	if flags&zipFlagDataDescriptor != 0 {
+		return nil
+	}
+	if crc := binary.LittleEndian.Uint32(header[14:]); crc != f.CRC32 {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local crc32 %08x differs from %08x", crc, f.CRC32)
+	}
+	if size := binary.LittleEndian.Uint32(header[18:]); size != zip64Marker && uint64(size) != f.CompressedSize64 {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local compressed size %d differs from %d", size, f.CompressedSize64)
+	}
+	if size := binary.LittleEndian.Uint32(header[22:]); size != zip64Marker && uint64(size) != f.UncompressedSize64 {
+		report.add(f.Name, ProblemHeaderMismatch, nil, "local size %d differs from %d", size, f.UncompressedSize64)
+	}
+	return nil
+}
+
+// findLocalHeader searches the local header in buf, which ends at the data of the entry
+func findLocalHeader(buf []byte, nameLen int) []byte {
+	for extraLen := 0; zipLocalHeaderSize+nameLen+extraLen <= len(buf); extraLen++ {
+		header := buf[len(buf)-zipLocalHeaderSize-nameLen-extraLen:]
+		if binary.LittleEndian.Uint32(header) == zipLocalHeaderSignature &&
+			int(binary.LittleEndian.Uint16(header[26:])) == nameLen &&
+			int(binary.LittleEndian.Uint16(header[28:])) == extraLen {
+			return header
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package zipasfolder
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+type zipStageEntry struct {
+	tmpFile string
+	modTime time.Time
+	dir     bool
+	// removed marks the deletion of an entry and all entries below it
+	removed bool
+}
+
+// zipStage contains all entries written to a zip file since the last commit
+type zipStage struct {
+	entries map[string]*zipStageEntry
+	open    int
+}
+
+func (zs *zipStage) clear() {
+	for _, entry := range zs.entries {
+		if entry.tmpFile != "" {
+			os.Remove(entry.tmpFile)
+		}
+	}
+	zs.entries = map[string]*zipStageEntry{}
+}
+
+// isRemoved checks whether name or one of its parents is removed
+func (zs *zipStage) isRemoved(name string) bool {
+	for dir := strings.TrimSuffix(name, "/"); dir != "."; dir = path.Dir(dir) {
+		if entry, ok := zs.entries[dir]; ok && entry.removed {
+			return true
+		}
+	}
+	return false
+}
+
+// zipStageWriter writes a zip entry to a temporary file and adds it to the stage on Close
+type zipStageWriter struct {
+	*os.File
+	fsys    *FS
+	zipFile string
+	name    string
+	closed  bool
+}
+
+func (zsw *zipStageWriter) Close() error {
+	if zsw.closed {
+		return errors.New("file already closed")
+	}
+	zsw.closed = true
+	err := zsw.File.Close()
+	zsw.fsys.stageLock.Lock()
+	defer zsw.fsys.stageLock.Unlock()
+	stage := zsw.fsys.stages[zsw.zipFile]
+	stage.open--
+	if err != nil {
+		os.Remove(zsw.File.Name())
+		return errors.Wrapf(err, "cannot close staging file for '%s'", zsw.name)
+	}
+	if old, ok := stage.entries[zsw.name]; ok && old.tmpFile != "" {
+		os.Remove(old.tmpFile)
+	}
+	stage.entries[zsw.name] = &zipStageEntry{
+		tmpFile: zsw.File.Name(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Abort discards the written data. The entry is not staged
+func (zsw *zipStageWriter) Abort() error {
+	if zsw.closed {
+		return errors.New("file already closed")
+	}
+	zsw.closed = true
+	zsw.File.Close()
+	os.Remove(zsw.File.Name())
+	zsw.fsys.stageLock.Lock()
+	defer zsw.fsys.stageLock.Unlock()
+	zsw.fsys.stages[zsw.zipFile].open--
+	return nil
+}
+
+func (fsys *FS) getStage(zipFile string) *zipStage {
+	stage, ok := fsys.stages[zipFile]
+	if !ok {
+		stage = &zipStage{entries: map[string]*zipStageEntry{}}
+		fsys.stages[zipFile] = stage
+	}
+	return stage
+}
+
+// createZIPEntry stages a new entry of a zip file. It becomes visible after Commit
+func (fsys *FS) createZIPEntry(zipFile, zipPath string) (FileW, error) {
+	if zipPath == "" || zipPath == "." {
+		return nil, errors.Errorf("cannot create zip file '%s' as entry", zipFile)
+	}
+	tmp, err := os.CreateTemp("", "zipasfolder-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create staging file for '%s/%s'", zipFile, zipPath)
+	}
+	fsys.stageLock.Lock()
+	defer fsys.stageLock.Unlock()
+	fsys.getStage(zipFile).open++
+	return &zipStageWriter{
+		File:    tmp,
+		fsys:    fsys,
+		zipFile: zipFile,
+		name:    zipPath,
+	}, nil
+}
+
+// mkDirZIPEntry stages a directory entry of a zip file. It becomes visible after Commit
+func (fsys *FS) mkDirZIPEntry(zipFile, zipPath string) error {
+	if zipPath == "" || zipPath == "." {
+		return errors.Errorf("cannot create zip file '%s' as directory", zipFile)
+	}
+	fsys.stageLock.Lock()
+	defer fsys.stageLock.Unlock()
+	fsys.getStage(zipFile).entries[zipPath+"/"] = &zipStageEntry{
+		modTime: time.Now(),
+		dir:     true,
+	}
+	return nil
+}
+
+// removeZIPEntry stages the removal of an entry or directory of a zip file. It becomes effective after Commit
+func (fsys *FS) removeZIPEntry(zipFile, zipPath string) error {
+	if zipPath == "" || zipPath == "." {
+		return errors.Errorf("cannot remove zip file '%s' as entry", zipFile)
+	}
+	fsys.stageLock.Lock()
+	defer fsys.stageLock.Unlock()
+	stage := fsys.getStage(zipFile)
+	var staged bool
+	for name, entry := range stage.entries {
+		if entry.removed {
+			continue
+		}
+		if name == zipPath || name == zipPath+"/" || strings.HasPrefix(name, zipPath+"/") {
+			if entry.tmpFile != "" {
+				os.Remove(entry.tmpFile)
+			}
+			delete(stage.entries, name)
+			staged = true
+		}
+	}
+	if !staged {
+		if _, err := fsys.Stat(zipFile + "/" + zipPath); err != nil {
+			return err
+		}
+	}
+	stage.entries[zipPath] = &zipStageEntry{
+		modTime: time.Now(),
+		removed: true,
+	}
+	return nil
+}
+
+// removeZIPFile removes a zip file with all staged entries
+func (fsys *FS) removeZIPFile(zipFile string) error {
+	defer fsys.lockArchive(zipFile)()
+	fsys.stageLock.Lock()
+	defer fsys.stageLock.Unlock()
+	if stage, ok := fsys.stages[zipFile]; ok {
+		if stage.open > 0 {
+			return errors.Errorf("%d entries of '%s' still open", stage.open, zipFile)
+		}
+		stage.clear()
+		delete(fsys.stages, zipFile)
+	}
+	fsys.Release(zipFile)
+	return fsys.baseFS.Remove(zipFile)
+}
+
+// commitLock serializes commits and removal of an archive. refs counts its users, it is
+// removed from FS.commitLocks with the last one
+type commitLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockArchive locks the commits and removal of zipFile and returns the unlock function.
+// Staging to other archives is not blocked by it
+func (fsys *FS) lockArchive(zipFile string) func() {
+	fsys.stageLock.Lock()
+	lock, ok := fsys.commitLocks[zipFile]
+	if !ok {
+		lock = &commitLock{}
+		fsys.commitLocks[zipFile] = lock
+	}
+	lock.refs++
+	fsys.stageLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		fsys.stageLock.Lock()
+		defer fsys.stageLock.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(fsys.commitLocks, zipFile)
+		}
+	}
+}
+
+// Commit writes all staged entries of zipFile. The archive is rebuilt in a temporary file
+// and renamed to zipFile afterwards. Readers which already opened the archive keep their snapshot,
+// new readers see the new content.
+func (fsys *FS) Commit(zipFile string) error {
+	defer fsys.lockArchive(zipFile)()
+	return fsys.commit(zipFile)
+}
+
+// commit rebuilds zipFile. The caller holds the archive lock of zipFile
+func (fsys *FS) commit(zipFile string) error {
+	fsys.stageLock.Lock()
+	stage, ok := fsys.stages[zipFile]
+	if !ok {
+		fsys.stageLock.Unlock()
+		return nil
+	}
+	if stage.open > 0 {
+		fsys.stageLock.Unlock()
+		return errors.Errorf("%d entries of '%s' still open", stage.open, zipFile)
+	}
+	// writes during the rebuild go to a new stage
+	delete(fsys.stages, zipFile)
+	fsys.stageLock.Unlock()
+
+	if err := fsys.writeArchive(zipFile, stage); err != nil {
+		fsys.restoreStage(zipFile, stage)
+		return err
+	}
+	stage.clear()
+
+	// new readers have to load the new archive
+	fsys.Release(zipFile)
+	return nil
+}
+
+// writeArchive writes the new archive to a temporary file and renames it to zipFile
+func (fsys *FS) writeArchive(zipFile string, stage *zipStage) error {
+	var rnd = make([]byte, 4)
+	rand.Read(rnd)
+	tmpName := fmt.Sprintf("%s.%x.tmp", zipFile, rnd)
+	tmpFile, err := fsys.baseFS.Create(tmpName)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create temporary file '%s'", tmpName)
+	}
+	if err := writeZIP(tmpFile, fsys.baseFS, zipFile, stage); err != nil {
+		tmpFile.Close()
+		fsys.removeBase(tmpName)
+		return errors.Wrapf(err, "cannot write '%s'", tmpName)
+	}
+	if err := tmpFile.Close(); err != nil {
+		fsys.removeBase(tmpName)
+		return errors.Wrapf(err, "cannot close '%s'", tmpName)
+	}
+	if err := fsys.baseFS.Rename(tmpName, zipFile); err != nil {
+		fsys.removeBase(tmpName)
+		return errors.Wrapf(err, "cannot rename '%s' to '%s'", tmpName, zipFile)
+	}
+	return nil
+}
+
+// restoreStage puts the entries of a failed commit back. Entries staged during the commit are newer and win
+func (fsys *FS) restoreStage(zipFile string, stage *zipStage) {
+	fsys.stageLock.Lock()
+	defer fsys.stageLock.Unlock()
+	current := fsys.getStage(zipFile)
+	for name, entry := range stage.entries {
+		if _, ok := current.entries[name]; ok {
+			if entry.tmpFile != "" {
+				os.Remove(entry.tmpFile)
+			}
+			continue
+		}
+		current.entries[name] = entry
+	}
+}
+
+func (fsys *FS) removeBase(name string) {
+	fsys.baseFS.Remove(name)
+}
+
+// writeZIP copies all entries of the existing archive which are not replaced and adds the staged entries
+func writeZIP(w io.Writer, baseFS FSRW, zipFile string, stage *zipStage) error {
+	zw := zip.NewWriter(w)
+	src, err := baseFS.Open(zipFile)
+	if err == nil {
+		defer src.Close()
+		stat, err := src.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "cannot stat '%s'", zipFile)
+		}
+		readerAt, ok := src.(io.ReaderAt)
+		if !ok {
+			return errors.Errorf("cannot cast file '%s' to io.ReaderAt", zipFile)
+		}
+		zipReader, err := zip.NewReader(readerAt, stat.Size())
+		if err != nil {
+			return errors.Wrapf(err, "cannot create zip reader for '%s'", zipFile)
+		}
+		for _, f := range zipReader.File {
+			if _, ok := stage.entries[f.Name]; ok || stage.isRemoved(f.Name) {
+				continue
+			}
+			if err := zw.Copy(f); err != nil {
+				return errors.Wrapf(err, "cannot copy '%s'", f.Name)
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrapf(err, "cannot open '%s'", zipFile)
+	}
+
+	names := make([]string, 0, len(stage.entries))
+	for name := range stage.entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		entry := stage.entries[name]
+		if entry.removed {
+			continue
+		}
+		header := &zip.FileHeader{
+			Name:     path.Clean(name),
+			Modified: entry.modTime,
+			Method:   zip.Deflate,
+		}
+		if entry.dir {
+			header.Name += "/"
+			header.Method = zip.Store
+			header.SetMode(fs.ModeDir | 0755)
+			if _, err := zw.CreateHeader(header); err != nil {
+				return errors.Wrapf(err, "cannot create directory '%s'", name)
+			}
+			continue
+		}
+		header.SetMode(0644)
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return errors.Wrapf(err, "cannot create entry '%s'", name)
+		}
+		fp, err := os.Open(entry.tmpFile)
+		if err != nil {
+			return errors.Wrapf(err, "cannot open staging file for '%s'", name)
+		}
+		_, err = io.Copy(dst, fp)
+		fp.Close()
+		if err != nil {
+			return errors.Wrapf(err, "cannot write entry '%s'", name)
+		}
+	}
+	return errors.Wrap(zw.Close(), "cannot finish zip file")
+}
+
+// commitAll commits all staged zip files
+func (fsys *FS) commitAll() error {
+	return fsys.commitBelow("")
+}
+
+// commitBelow commits the staged zip file dir and all staged zip files within dir
+func (fsys *FS) commitBelow(dir string) error {
+	fsys.stageLock.Lock()
+	var zipFiles = []string{}
+	for zipFile := range fsys.stages {
+		if dir != "" && zipFile != dir && !strings.HasPrefix(zipFile, dir+"/") {
+			continue
+		}
+		zipFiles = append(zipFiles, zipFile)
+	}
+	fsys.stageLock.Unlock()
+	var errs = []error{}
+	for _, zipFile := range zipFiles {
+		if err := fsys.Commit(zipFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Combine(errs...)
+}
+
+var (
+	_ FileWAbort = &zipStageWriter{}
+)